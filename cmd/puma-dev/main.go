@@ -6,6 +6,9 @@ import (
 	"os"
 	"runtime"
 	"strings"
+
+	"github.com/puma/puma-dev/dev"
+	"github.com/puma/puma-dev/linebuffer"
 )
 
 var (
@@ -15,6 +18,66 @@ var (
 
 	fVersion = flag.Bool("V", false, "display version info")
 	Version  = "devel"
+
+	fTLSCerts   = certFlag{}
+	fAliases    = aliasFlag{}
+	fPathRoutes = pathRouteFlag{}
+)
+
+// Flags for the proxy itself, shared by every platform.
+var (
+	fAdminCORSOrigin    = flag.String("admin-cors-origin", "", "origin allowed to make cross-origin requests to the admin API")
+	fAdminHost          = flag.String("admin-host", dev.DefaultAdminHost, "host to answer status and control API requests on")
+	fAdminRateLimit     = flag.Float64("admin-rate-limit", 0, "how many admin API requests to answer a second, with 429s past that (0 for unlimited)")
+	fAllowedCIDRs       = flag.String("allowed-cidrs", "", "only serve clients in these IP ranges, separate with , (empty for everyone)")
+	fBlockTraversal     = flag.Bool("block-path-traversal", false, "answer requests with .. in their path with a 400 instead of passing them on")
+	fBootConcurrency    = flag.Int("boot-concurrency", 0, "how many apps may boot at once, higher priority apps first (0 for unlimited)")
+	fBootInterstitial   = flag.Bool("boot-interstitial", false, "show browsers a page that reloads once a booting app is ready")
+	fCircuitCooldown    = flag.Duration("circuit-breaker-cooldown", dev.DefaultCircuitBreakerCooldown, "how long requests to a failing app get a 503 straight away")
+	fCircuitFailures    = flag.Int("circuit-breaker-failures", 0, "fail requests to an app fast after this many failures in a row (0 to disable)")
+	fCircuitWindow      = flag.Duration("circuit-breaker-window", dev.DefaultCircuitBreakerWindow, "how close together failures have to be to count towards -circuit-breaker-failures")
+	fClientCertCAs      = flag.String("client-cert-ca", "", "ask https clients for a certificate, verifying it against the CAs in this PEM file")
+	fCoalesceRequests   = flag.Bool("coalesce-requests", false, "send identical GETs that arrive together, e.g. prefetches while an app boots, to the app once")
+	fDebugRouting       = flag.Bool("debug-routing", false, "route requests with a "+dev.DebugRoutingParam+" query parameter to the app it names, for development only")
+	fDecompressRequests = flag.Bool("decompress-requests", false, "pass gzipped request bodies on to apps decompressed")
+	fDeniedCIDRs        = flag.String("denied-cidrs", "", "refuse clients in these IP ranges with a 403, separate with ,")
+	fDefaultApp         = flag.String("default-app", "", "app to send requests for hosts that match no app to")
+	fDirCheckInterval   = flag.Duration("dir-check-interval", dev.DefaultDirCheckInterval, "how often running apps check that their directory still exists")
+	fDisableKeepAlives  = flag.Bool("disable-keepalives", false, "open a new connection to the app for every request")
+	fDisablePCORouting  = flag.Bool("disable-pco-routing", false, "route by hostname alone, without the api.pco and Church Center rewrites")
+	fDisableSendfile    = flag.Bool("disable-sendfile", false, "copy static files through a buffer instead of using sendfile")
+	fEngineHostHeader   = flag.String("engine-host-header", dev.DefaultEngineHostHeader, "header set to the original host on requests rewritten to another app")
+	fEventsBlockTimeout = flag.Duration("events-block-timeout", linebuffer.DefaultBlockTimeout, "how long new events wait for room with -events-overflow block")
+	fEventsOverflow     = flag.String("events-overflow", linebuffer.DropOldest.String(), "what to do with new events once the buffer is full: drop-oldest, drop-newest or block")
+	fFilterAdmin        = flag.Bool("filter-admin", false, "apply -allowed-cidrs and -denied-cidrs to the admin API too")
+	fFlushStreaming     = flag.Bool("flush-streaming-only", false, "flush only -streaming-content-types responses as they arrive, sending others whole")
+	fForwardedHeader    = flag.Bool("forwarded-header", false, "also send apps the RFC 7239 Forwarded header")
+	fGlobalStaticDir    = flag.String("global-static-dir", "", "serve files such as robots.txt and favicon.ico from here to apps that don't have their own")
+	fHTTP10Response     = flag.String("http10-response", dev.HTTP10Close, "how to pass on app responses without a length to HTTP/1.0 clients: close or buffer")
+	fIdleConnTimeout    = flag.Duration("idle-conn-timeout", 0, "how long idle connections to apps are kept open (0 for no limit)")
+	fJSONLogging        = flag.Bool("json-logging", false, "log every request to stderr as a JSON line")
+	fMaxConnsPerIP      = flag.Int("max-conns-per-ip", 0, "how many connections one client IP may have open, with 503s past that (0 for unlimited)")
+	fMaxIdleConns       = flag.Int("max-idle-conns", 0, "how many idle connections to apps to keep open in total (0 for no limit)")
+	fMaxIdlePerHost     = flag.Int("max-idle-conns-per-host", 0, "how many idle connections to keep open to each app (0 for Go's default of 2)")
+	fMaxRespHeader      = flag.Int64("max-response-header-bytes", 0, "answer with a 502 when an app's response headers are larger than this (0 for Go's default of 10MB)")
+	fPrebootApps        = flag.String("preboot", "", "apps to boot at startup rather than on their first request, separate with :")
+	fProxyProtocol      = flag.Bool("proxy-protocol", false, "expect a PROXY protocol v1 header on every http and https connection")
+	fRecord             = flag.String("record", "", "record proxied requests and responses to this file")
+	fReplay             = flag.String("replay", "", "serve recorded responses from this file instead of the apps")
+	fReplayMatchHeaders = flag.String("replay-match-headers", "", "headers that must also match when replaying, separate with :")
+	fRequestTimeout     = flag.Duration("request-timeout", 0, "how long a proxied request may take in full, except for -streaming-paths (0 for no limit)")
+	fResponseHeaderWait = flag.Duration("response-header-timeout", 0, "how long apps may take to start responding (0 for no limit)")
+	fRetryConnReset     = flag.Bool("retry-on-conn-reset", true, "send GET, HEAD, PUT and DELETE requests again once when the app resets the connection")
+	fServeRootIndex     = flag.Bool("serve-root-index", false, "serve public/index.html for /, where an app has one, instead of asking the app")
+	fSlowRequest        = flag.Duration("slow-request-threshold", 0, "record a slow_request event for requests taking longer than this")
+	fStreamThreshold    = flag.Int64("stream-threshold", 0, "flush responses larger than this many bytes to the client as they arrive (0 to disable)")
+	fStreamingTypes     = flag.String("streaming-content-types", strings.Join(dev.DefaultStreamingContentTypes, ":"), "content types -flush-streaming-only flushes, separate with :")
+	fStreamingPaths     = flag.String("streaming-paths", "", "path prefixes exempt from -request-timeout, separate with :")
+	fStripReqHeaders    = flag.String("strip-request-headers", "", "headers to remove from requests before passing them on, separate with :")
+	fTruncatedResponse  = flag.String("truncated-response", dev.TruncatedAbort, "what clients get when an app closes the connection mid-response: abort or mark")
+	fTrustEngineHost    = flag.Bool("trust-engine-host", false, "keep an -engine-host-header the client already set, for chained proxies")
+	fUnframedResponse   = flag.String("unframed-response", dev.UnframedChunk, "how to pass on app responses without a length: chunk, close or buffer")
+	fWellKnownDir       = flag.String("well-known-dir", "", "serve /.well-known/acme-challenge/ for every host from the acme-challenge directory in here")
 )
 
 type CommandResult struct {
@@ -30,6 +93,208 @@ func (a ByDecreasingTLDComplexity) Less(i, j int) bool {
 	return strings.Count(a[i], ".") > strings.Count(a[j], ".")
 }
 
+// certFlag collects the certificates given with -tls-cert, each as
+// host=cert.pem,key.pem.
+type certFlag map[string]dev.CertFiles
+
+func (c certFlag) String() string {
+	var specs []string
+
+	for host, files := range c {
+		specs = append(specs, fmt.Sprintf("%s=%s,%s", host, files.CertFile, files.KeyFile))
+	}
+
+	return strings.Join(specs, " ")
+}
+
+func (c certFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) == 2 {
+		files := strings.Split(parts[1], ",")
+		if parts[0] != "" && len(files) == 2 && files[0] != "" && files[1] != "" {
+			c[parts[0]] = dev.CertFiles{CertFile: files[0], KeyFile: files[1]}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("expected host=cert.pem,key.pem, got '%s'", value)
+}
+
+// aliasFlag collects the app aliases given with -alias, each as name=app.
+type aliasFlag map[string]string
+
+func (a aliasFlag) String() string {
+	var specs []string
+
+	for name, app := range a {
+		specs = append(specs, name+"="+app)
+	}
+
+	return strings.Join(specs, " ")
+}
+
+func (a aliasFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected name=app, got '%s'", value)
+	}
+
+	a[parts[0]] = parts[1]
+
+	return nil
+}
+
+// pathRouteFlag collects the routes given with -path-route, each as
+// /prefix=app, with ,strip to take the prefix off the path.
+type pathRouteFlag []dev.PathRoute
+
+func (p *pathRouteFlag) String() string {
+	var specs []string
+
+	for _, pr := range *p {
+		spec := pr.Prefix + "=" + pr.App
+		if pr.Strip {
+			spec += ",strip"
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return strings.Join(specs, " ")
+}
+
+func (p *pathRouteFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "/") || parts[1] == "" {
+		return fmt.Errorf("expected /prefix=app or /prefix=app,strip, got '%s'", value)
+	}
+
+	pr := dev.PathRoute{Prefix: parts[0], App: parts[1]}
+
+	if app := strings.TrimSuffix(pr.App, ",strip"); app != pr.App {
+		pr.App, pr.Strip = app, true
+	}
+
+	if pr.App == "" || strings.Contains(pr.App, ",") {
+		return fmt.Errorf("expected /prefix=app or /prefix=app,strip, got '%s'", value)
+	}
+
+	*p = append(*p, pr)
+
+	return nil
+}
+
+// configurePool applies the shared flags to the pool and its events.
+func configurePool(pool *dev.AppPool, events *dev.Events) error {
+	overflow, err := linebuffer.ParseOverflowPolicy(*fEventsOverflow)
+	if err != nil {
+		return fmt.Errorf("invalid -events-overflow: %s", err)
+	}
+
+	events.SetOverflowPolicy(overflow, *fEventsBlockTimeout)
+
+	pool.BootConcurrency = *fBootConcurrency
+	pool.DirCheckInterval = *fDirCheckInterval
+
+	return nil
+}
+
+// configureHTTPServer applies the shared flags to h.
+func configureHTTPServer(h *dev.HTTPServer) error {
+	h.ExpectProxyProtocol = *fProxyProtocol
+	h.ClientCertCAFile = *fClientCertCAs
+	h.CustomCerts = fTLSCerts
+	h.Aliases = fAliases
+	h.PathRoutes = fPathRoutes
+	h.DefaultApp = *fDefaultApp
+	h.MaxConnsPerIP = *fMaxConnsPerIP
+	h.JSONLogging = *fJSONLogging
+	h.AdminHost = *fAdminHost
+	h.AdminCORSOrigin = *fAdminCORSOrigin
+	h.AdminRateLimit = *fAdminRateLimit
+	h.WellKnownDir = *fWellKnownDir
+	h.GlobalStaticDir = *fGlobalStaticDir
+	h.ServeRootIndex = *fServeRootIndex
+	h.RecordFile = *fRecord
+	h.ReplayFile = *fReplay
+	if len(*fReplayMatchHeaders) > 0 {
+		h.ReplayMatchHeaders = strings.Split(*fReplayMatchHeaders, ":")
+	}
+	h.SlowRequestThreshold = *fSlowRequest
+	h.ResponseHeaderTimeout = *fResponseHeaderWait
+	h.RequestTimeout = *fRequestTimeout
+	h.DisableKeepAlives = *fDisableKeepAlives
+	h.MaxIdleConns = *fMaxIdleConns
+	h.MaxIdleConnsPerHost = *fMaxIdlePerHost
+	h.IdleConnTimeout = *fIdleConnTimeout
+	h.MaxResponseHeaderBytes = *fMaxRespHeader
+	h.DisableSendfile = *fDisableSendfile
+	h.DisablePCORouting = *fDisablePCORouting
+	h.DebugRouting = *fDebugRouting
+	h.BlockPathTraversal = *fBlockTraversal
+	h.DecompressRequests = *fDecompressRequests
+	h.BootInterstitial = *fBootInterstitial
+	if *fPrebootApps != "" {
+		h.PrebootApps = strings.Split(*fPrebootApps, ":")
+	}
+	h.CoalesceRequests = *fCoalesceRequests
+	h.RetryOnConnReset = *fRetryConnReset
+	h.UseForwardedHeader = *fForwardedHeader
+	h.EngineHostHeader = *fEngineHostHeader
+	h.TrustEngineHostHeader = *fTrustEngineHost
+	h.StreamThreshold = *fStreamThreshold
+	h.CircuitBreakerFailures = *fCircuitFailures
+	h.CircuitBreakerWindow = *fCircuitWindow
+	h.CircuitBreakerCooldown = *fCircuitCooldown
+	if *fStripReqHeaders != "" {
+		h.StripRequestHeaders = strings.Split(*fStripReqHeaders, ":")
+	}
+	if *fStreamingPaths != "" {
+		h.StreamingPaths = strings.Split(*fStreamingPaths, ":")
+	}
+	h.FlushStreamingOnly = *fFlushStreaming
+	if *fStreamingTypes != "" {
+		h.StreamingContentTypes = strings.Split(*fStreamingTypes, ":")
+	}
+
+	if *fAllowedCIDRs != "" {
+		h.AllowedCIDRs = strings.Split(*fAllowedCIDRs, ",")
+		if _, err := dev.ParseCIDRs(h.AllowedCIDRs); err != nil {
+			return fmt.Errorf("-allowed-cidrs: %s", err)
+		}
+	}
+	if *fDeniedCIDRs != "" {
+		h.DeniedCIDRs = strings.Split(*fDeniedCIDRs, ",")
+		if _, err := dev.ParseCIDRs(h.DeniedCIDRs); err != nil {
+			return fmt.Errorf("-denied-cidrs: %s", err)
+		}
+	}
+	h.FilterAdmin = *fFilterAdmin
+
+	switch *fUnframedResponse {
+	case dev.UnframedChunk, dev.UnframedClose, dev.UnframedBuffer:
+		h.UnframedResponseMode = *fUnframedResponse
+	default:
+		return fmt.Errorf("invalid -unframed-response mode: %s", *fUnframedResponse)
+	}
+
+	switch *fTruncatedResponse {
+	case dev.TruncatedAbort, dev.TruncatedMark:
+		h.TruncatedResponseMode = *fTruncatedResponse
+	default:
+		return fmt.Errorf("invalid -truncated-response mode: %s", *fTruncatedResponse)
+	}
+
+	switch *fHTTP10Response {
+	case dev.HTTP10Close, dev.HTTP10Buffer:
+		h.HTTP10ResponseMode = *fHTTP10Response
+	default:
+		return fmt.Errorf("invalid -http10-response mode: %s", *fHTTP10Response)
+	}
+
+	return nil
+}
+
 func allCheck() {
 	if result := execWithExitStatus(); result.shouldExit {
 		os.Exit(result.exitStatusCode)
@@ -57,6 +322,10 @@ func execWithExitStatus() CommandResult {
 }
 
 func init() {
+	flag.Var(fAliases, "alias", "send requests for one app to another, as name=app (repeatable)")
+	flag.Var(&fPathRoutes, "path-route", "send requests for hosts that match no app to an app by path, as /prefix=app or /prefix=app,strip to take the prefix off (repeatable)")
+	flag.Var(fTLSCerts, "tls-cert", "serve this certificate for a host instead of a generated one, as host=cert.pem,key.pem (repeatable, host may be *.domain)")
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
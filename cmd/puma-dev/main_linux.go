@@ -65,6 +65,11 @@ func main() {
 	pool.IdleTime = *fTimeout
 	pool.Events = &events
 
+	err = configurePool(&pool, &events)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
 	purge := make(chan os.Signal, 1)
 	signal.Notify(purge, syscall.SIGUSR1)
 
@@ -103,6 +108,12 @@ func main() {
 	http.Debug = *fDebug
 	http.Events = &events
 	http.Domains = domains
+
+	err = configureHTTPServer(&http)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
 	if len(*fNoServePublicPaths) > 0 {
 		http.IgnoredStaticPaths = strings.Split(*fNoServePublicPaths, ":")
 		fmt.Printf("* Ignoring files under: public{%s}\n", strings.Join(http.IgnoredStaticPaths, ", "))
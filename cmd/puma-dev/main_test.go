@@ -55,6 +55,37 @@ func TestMain_execWithExitStatus_noFlag(t *testing.T) {
 	assert.Equal(t, "", execStdOut)
 }
 
+func TestMain_configureHTTPServer(t *testing.T) {
+	var h dev.HTTPServer
+
+	assert.NoError(t, configureHTTPServer(&h))
+	assert.Equal(t, dev.HTTP10Close, h.HTTP10ResponseMode)
+	assert.Equal(t, dev.DefaultAdminHost, h.AdminHost)
+
+	orig := *fHTTP10Response
+	defer func() { *fHTTP10Response = orig }()
+
+	*fHTTP10Response = "stream"
+
+	assert.EqualError(t, configureHTTPServer(&h), "invalid -http10-response mode: stream")
+}
+
+func TestMain_configureHTTPServer_cidrs(t *testing.T) {
+	var h dev.HTTPServer
+
+	orig := *fDeniedCIDRs
+	defer func() { *fDeniedCIDRs = orig }()
+
+	*fDeniedCIDRs = "10.0.0.0/8,192.168.1.5"
+
+	assert.NoError(t, configureHTTPServer(&h))
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.5"}, h.DeniedCIDRs)
+
+	*fDeniedCIDRs = "10.0.0.0/33"
+
+	assert.EqualError(t, configureHTTPServer(&h), "-denied-cidrs: invalid IP or CIDR '10.0.0.0/33'")
+}
+
 func TestMain_execWithExitStatus_commandArgs(t *testing.T) {
 	StubCommandLineArgs("nosoupforyou")
 
@@ -100,6 +131,51 @@ func TestMain_allCheck_badArg(t *testing.T) {
 	assert.False(t, exit.Success())
 }
 
+func TestMain_certFlag(t *testing.T) {
+	certs := certFlag{}
+
+	assert.NoError(t, certs.Set("shop.test=shop.pem,shop-key.pem"))
+	assert.NoError(t, certs.Set("*.corp.example=corp.pem,corp-key.pem"))
+
+	assert.Equal(t, certFlag{
+		"shop.test":      {CertFile: "shop.pem", KeyFile: "shop-key.pem"},
+		"*.corp.example": {CertFile: "corp.pem", KeyFile: "corp-key.pem"},
+	}, certs)
+
+	for _, bad := range []string{"shop.test", "shop.test=shop.pem", "=a.pem,b.pem", "shop.test=a.pem,"} {
+		assert.Error(t, certs.Set(bad), bad)
+	}
+}
+
+func TestMain_aliasFlag(t *testing.T) {
+	aliases := aliasFlag{}
+
+	assert.NoError(t, aliases.Set("billing=payments-service"))
+	assert.NoError(t, aliases.Set("docs.pco=docs"))
+
+	assert.Equal(t, aliasFlag{"billing": "payments-service", "docs.pco": "docs"}, aliases)
+
+	for _, bad := range []string{"billing", "=payments", "billing="} {
+		assert.Error(t, aliases.Set(bad), bad)
+	}
+}
+
+func TestMain_pathRouteFlag(t *testing.T) {
+	routes := pathRouteFlag{}
+
+	assert.NoError(t, routes.Set("/app1=app1"))
+	assert.NoError(t, routes.Set("/app2/=app2,strip"))
+
+	assert.Equal(t, pathRouteFlag{
+		{Prefix: "/app1", App: "app1"},
+		{Prefix: "/app2/", App: "app2", Strip: true},
+	}, routes)
+
+	for _, bad := range []string{"/app1", "app1=app1", "/app1=", "/app1=,strip", "/app1=app1,keep"} {
+		assert.Error(t, routes.Set(bad), bad)
+	}
+}
+
 func configureAndBootPumaDevServer(t *testing.T, mainFlags map[string]string) error {
 	StubCommandLineArgs()
 	for flagName, flagValue := range mainFlags {
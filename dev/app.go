@@ -8,11 +8,9 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -36,9 +34,11 @@ type App struct {
 	Command *exec.Cmd
 	Public  bool
 	Events  *Events
+	Config  AppConfig
 
 	lines       linebuffer.LineBuffer
 	lastLogLine string
+	logFile     *rotatingFile
 
 	address string
 	dir     string
@@ -46,14 +46,25 @@ type App struct {
 	t tomb.Tomb
 
 	stdout  io.Reader
+	stdin   io.WriteCloser
 	pool    *AppPool
 	lastUse time.Time
 
+	// The app's pseudo-terminal with TTY, and our copy of its end, closed
+	// once the process has it.
+	pty *os.File
+	tty *os.File
+
 	lock sync.Mutex
 
-	booting bool
+	launchRetries int
+	killed        bool
+	dirMissing    sync.Once
 
 	readyChan chan struct{}
+
+	// The backends of a proxy app.
+	balancer balancer
 }
 
 func (a *App) eventAdd(name string, args ...interface{}) {
@@ -63,6 +74,15 @@ func (a *App) eventAdd(name string, args ...interface{}) {
 	a.lines.Append("#event " + str)
 }
 
+// eventTryAdd is eventAdd for callers holding the pool's lock. See
+// Events.TryAdd.
+func (a *App) eventTryAdd(name string, args ...interface{}) {
+	args = append([]interface{}{"app", a.Name}, args...)
+
+	str := a.Events.TryAdd(name, args...)
+	a.lines.Append("#event " + str)
+}
+
 func (a *App) SetAddress(scheme, host string, port int) {
 	a.Scheme = scheme
 	a.Host = host
@@ -83,17 +103,66 @@ func (a *App) Address() string {
 	return fmt.Sprintf("%s:%d", a.Host, a.Port)
 }
 
+// command returns the app's current process, which retryLaunch replaces
+// on every retry.
+func (a *App) command() *exec.Cmd {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.Command
+}
+
+// pid returns the app's process ID, zero for proxy apps and apps still
+// queued for boot.
+func (a *App) pid() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.Command == nil || a.Command.Process == nil {
+		return 0
+	}
+
+	return a.Command.Process.Pid
+}
+
+func (a *App) wasKilled() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.killed
+}
+
+func (a *App) lastLine() string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	return a.lastLogLine
+}
+
 func (a *App) Kill(reason string) error {
+	a.lock.Lock()
+	cmd := a.Command
+	a.killed = true
+	a.lock.Unlock()
+
+	if cmd.Process == nil {
+		// Still queued for boot, so there is no process to signal yet.
+		a.eventAdd("killing_app", "reason", reason)
+		a.pool.remove(a)
+		a.t.Kill(fmt.Errorf("app stopped before booting: %s", reason))
+		return nil
+	}
+
 	a.eventAdd("killing_app",
-		"pid", a.Command.Process.Pid,
+		"pid", cmd.Process.Pid,
 		"reason", reason,
 	)
 
-	fmt.Printf("! Killing '%s' (%d) - '%s'\n", a.Name, a.Command.Process.Pid, reason)
-	err := a.Command.Process.Signal(syscall.SIGTERM)
+	fmt.Printf("! Killing '%s' (%d) - '%s'\n", a.Name, cmd.Process.Pid, reason)
+	err := cmd.Process.Signal(syscall.SIGTERM)
 	if err != nil {
 		a.eventAdd("killing_error",
-			"pid", a.Command.Process.Pid,
+			"pid", cmd.Process.Pid,
 			"error", err.Error(),
 		)
 		fmt.Printf("! Error trying to kill %s: %s", a.Name, err)
@@ -107,47 +176,61 @@ func (a *App) Kill(reason string) error {
 	return err
 }
 
+// Restart stops the app, or for proxy apps drops it so its config is re-read.
+func (a *App) Restart(reason string) error {
+	if a.command() == nil {
+		a.eventAdd("restarting_proxy", "reason", reason)
+		a.pool.remove(a)
+		a.t.Kill(nil)
+		return nil
+	}
+
+	return a.Kill(reason)
+}
+
 func (a *App) watch() error {
-	c := make(chan error)
+	var err error
+
+	reason := "detected interval shutdown"
 
-	go func() {
-		r := bufio.NewReader(a.stdout)
+	for {
+		a.lock.Lock()
+		cmd, stdout := a.Command, a.stdout
+		a.lock.Unlock()
 
-		for {
-			line, err := r.ReadString('\n')
-			if line != "" {
-				a.lines.Append(line)
-				a.lastLogLine = line
-				fmt.Fprintf(os.Stdout, "%s[%d]: %s", a.Name, a.Command.Process.Pid, line)
-			}
+		c := make(chan error, 1)
 
-			if err != nil {
-				c <- err
-				return
-			}
-		}
-	}()
+		go a.readOutput(stdout, cmd.Process.Pid, c)
 
-	var err error
+		select {
+		case <-c:
+			if a.retryLaunch() {
+				continue
+			}
 
-	reason := "detected interval shutdown"
+			reason = "stdout/stderr closed"
+			err = fmt.Errorf("%s:\n\t%s", ErrUnexpectedExit, a.lastLine())
+		case <-a.t.Dying():
+			err = nil
+		}
 
-	select {
-	case err = <-c:
-		reason = "stdout/stderr closed"
-		err = fmt.Errorf("%s:\n\t%s", ErrUnexpectedExit, a.lastLogLine)
-	case <-a.t.Dying():
-		err = nil
+		break
 	}
 
 	a.Kill(reason)
-	a.Command.Wait()
+	a.command().Wait()
 	a.pool.remove(a)
 
+	a.lock.Lock()
+	a.closePty()
+	a.lock.Unlock()
+
 	if a.Scheme == "httpu" {
 		os.Remove(a.Address())
 	}
 
+	a.closeLogFile()
+
 	a.eventAdd("shutdown")
 
 	fmt.Printf("* App '%s' shutdown and cleaned up\n", a.Name)
@@ -155,6 +238,112 @@ func (a *App) watch() error {
 	return err
 }
 
+func (a *App) closeLogFile() {
+	if a.logFile != nil {
+		a.logFile.Close()
+	}
+}
+
+func (a *App) readOutput(stdout io.Reader, pid int, c chan error) {
+	r := bufio.NewReader(stdout)
+
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			// Terminals turn newlines into CRLF.
+			if a.Config.TTY && strings.HasSuffix(line, "\r\n") {
+				line = line[:len(line)-2] + "\n"
+			}
+
+			a.lines.Append(line)
+
+			a.lock.Lock()
+			a.lastLogLine = line
+			a.lock.Unlock()
+
+			if a.logFile != nil {
+				a.logFile.Write([]byte(line))
+			}
+
+			fmt.Fprintf(os.Stdout, "%s[%d]: %s", a.Name, pid, line)
+		}
+
+		if err != nil {
+			c <- err
+			return
+		}
+	}
+}
+
+// retryLaunch relaunches an app that exited while booting, returning false
+// once it's out of retries.
+func (a *App) retryLaunch() bool {
+	select {
+	case <-a.readyChan:
+		return false
+	default:
+	}
+
+	for a.launchRetries < a.Config.LaunchRetries {
+		if cmd := a.command(); cmd.Process != nil {
+			cmd.Process.Signal(syscall.SIGTERM)
+			cmd.Wait()
+		}
+
+		a.launchRetries++
+
+		backoff := a.Config.LaunchRetryBackoff
+		if backoff <= 0 {
+			backoff = DefaultLaunchRetryBackoff
+		}
+
+		delay := backoff << uint(a.launchRetries-1)
+
+		a.eventAdd("retrying_launch",
+			"attempt", a.launchRetries,
+			"delay", delay.String(),
+			"last_line", strings.TrimSpace(a.lastLine()),
+		)
+
+		fmt.Printf("! App '%s' exited while booting, retrying in %s (%d/%d)\n",
+			a.Name, delay, a.launchRetries, a.Config.LaunchRetries)
+
+		select {
+		case <-time.After(delay):
+		case <-a.t.Dying():
+			return false
+		}
+
+		if a.wasKilled() {
+			return false
+		}
+
+		err := a.prepareCommand()
+		if err == nil {
+			a.lock.Lock()
+
+			// Kill takes the lock too, so either it sees the new process
+			// or we see that the app was stopped on purpose.
+			if a.killed {
+				a.lock.Unlock()
+				return false
+			}
+
+			err = a.startCommand()
+			a.lock.Unlock()
+		}
+
+		if err == nil {
+			a.eventAdd("booting_app", "socket", a.Address(), "attempt", a.launchRetries)
+			return true
+		}
+
+		a.eventAdd("launch_error", "error", err.Error())
+	}
+
+	return false
+}
+
 func (a *App) idleMonitor() error {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
@@ -172,6 +361,46 @@ func (a *App) idleMonitor() error {
 	}
 }
 
+const DefaultDirCheckInterval = 5 * time.Second
+
+// dirMonitor stops the app once its directory, or the symlink's target, is
+// deleted.
+func (a *App) dirMonitor() error {
+	interval := a.pool.DirCheckInterval
+	if interval <= 0 {
+		interval = DefaultDirCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if a.checkDir() {
+				return nil
+			}
+		case <-a.t.Dying():
+			return nil
+		}
+	}
+}
+
+// checkDir kills the app if its directory is gone, returning whether it was.
+func (a *App) checkDir() bool {
+	if _, err := os.Stat(a.dir); !os.IsNotExist(err) {
+		return false
+	}
+
+	a.dirMissing.Do(func() {
+		a.eventAdd("app_directory_missing", "dir", a.dir)
+		fmt.Printf("! Directory of app '%s' is missing: %s\n", a.Name, a.dir)
+		a.Kill("app directory missing")
+	})
+
+	return true
+}
+
 func (a *App) restartMonitor() error {
 	tmpDir := filepath.Join(a.dir, "tmp")
 	err := os.MkdirAll(tmpDir, 0755)
@@ -187,9 +416,36 @@ func (a *App) restartMonitor() error {
 	}
 	f.Close()
 
-	return watch.Watch(restart, a.t.Dying(), func() {
+	err = watch.Watch(restart, a.t.Dying(), func() {
 		a.Kill("restart.txt touched")
 	})
+
+	// Deleting the app takes restart.txt with it.
+	if err != nil && a.checkDir() {
+		return nil
+	}
+
+	return err
+}
+
+// bundleFiles are watched for apps with RestartOnBundleChange.
+var bundleFiles = []string{"Gemfile", "Gemfile.lock"}
+
+// bundleMonitor restarts the app once one of its bundleFiles changes.
+func (a *App) bundleMonitor(name string) error {
+	reason := name + " changed"
+
+	err := watch.Watch(filepath.Join(a.dir, name), a.t.Dying(), func() {
+		a.Kill(reason)
+	})
+
+	// Bundler replaces the lockfile rather than writing to it, which can
+	// leave the watch without a file to look at. That's a change too.
+	if err != nil {
+		a.Kill(reason)
+	}
+
+	return nil
 }
 
 func (a *App) WaitTilReady() error {
@@ -200,7 +456,9 @@ func (a *App) WaitTilReady() error {
 		case <-a.t.Dying():
 			return a.t.Err()
 		default:
+			a.lock.Lock()
 			a.lastUse = time.Now()
+			a.lock.Unlock()
 			return nil
 		}
 	case <-a.t.Dying():
@@ -214,6 +472,9 @@ const (
 	Dead
 )
 
+// Status reports whether the app is Booting, Running or Dead. It is Dead
+// once its tomb is dying, which covers failed boots as well as shutdowns,
+// and Booting until then if it isn't ready yet, including while queued.
 func (a *App) Status() int {
 	// These are done in order as separate selects because go's
 	// select does not execute case's sequentially, it runs bodies
@@ -226,7 +487,7 @@ func (a *App) Status() int {
 		case <-a.readyChan:
 			return Running
 		default:
-			return Dead
+			return Booting
 		}
 	}
 }
@@ -272,15 +533,157 @@ fi
 exec $devbox_prefix puma -C $CONFIG --tag puma-dev:%s -w $WORKERS -t 0:$THREADS -b unix:%s'
 `
 
+// appCommand builds the command used to boot the app in dir, bound to
+// socket. It is a variable so tests can boot something other than puma.
+var appCommand = func(shell, dir, name, socket string) *exec.Cmd {
+	return exec.Command(shell, "-l", "-i", "-c",
+		fmt.Sprintf(executionShell, dir, name, socket, name, socket))
+}
+
+// cleanEnvRequired are the variables apps with a clean environment still get,
+// as the login shell that boots them can't do without.
+var cleanEnvRequired = []string{"HOME", "PATH"}
+
+// baseEnv returns the environment the app is started with, before the puma
+// settings are added.
+func (a *App) baseEnv() []string {
+	if !a.Config.CleanEnv {
+		return os.Environ()
+	}
+
+	var env []string
+
+	for _, names := range [][]string{cleanEnvRequired, a.Config.EnvAllowlist} {
+		for _, name := range names {
+			if val, ok := os.LookupEnv(name); ok {
+				env = append(env, name+"="+val)
+			}
+		}
+	}
+
+	return env
+}
+
 func (pool *AppPool) LaunchApp(name, dir string) (*App, error) {
+	cfg, err := LoadAppConfig(appConfigPath(dir, true))
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.upstream != nil {
+		return pool.upstreamApp(name, dir, cfg), nil
+	}
+
 	tmpDir := filepath.Join(dir, "tmp")
-	err := os.MkdirAll(tmpDir, 0755)
+	err = os.MkdirAll(tmpDir, 0755)
 	if err != nil {
 		return nil, err
 	}
 
 	socket := filepath.Join(tmpDir, fmt.Sprintf("puma-dev-%d.sock", os.Getpid()))
 
+	app := &App{
+		Name:      name,
+		Events:    pool.Events,
+		Config:    cfg,
+		dir:       dir,
+		pool:      pool,
+		readyChan: make(chan struct{}),
+		lastUse:   time.Now(),
+	}
+
+	stat, err := os.Stat(filepath.Join(dir, cfg.publicDir()))
+	if err == nil {
+		app.Public = stat.IsDir()
+	}
+
+	app.SetAddress("httpu", socket, 0)
+
+	if cfg.LogFile != "" {
+		path := cfg.LogFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+
+		app.logFile, err = openRotatingFile(path, cfg.LogMaxSize, cfg.LogMaxFiles)
+		if err != nil {
+			return nil, errors.Context(err, "opening log file")
+		}
+	}
+
+	err = app.prepareCommand()
+	if err != nil {
+		app.closeLogFile()
+		return nil, err
+	}
+
+	if pool.boots.tryAcquire(pool.BootConcurrency) {
+		err = app.start()
+		if err != nil {
+			pool.boots.release(pool.BootConcurrency)
+			app.closeLogFile()
+			return nil, err
+		}
+
+		return app, nil
+	}
+
+	// Too many apps are booting already, so wait our turn in the background
+	// rather than holding up the pool.
+	app.eventTryAdd("queued_for_boot", "priority", cfg.Priority)
+	fmt.Printf("! Queued app '%s' for boot (priority %d)\n", name, cfg.Priority)
+
+	app.t.Go(func() error {
+		if !pool.boots.acquire(pool.BootConcurrency, cfg.Priority, app.t.Dying()) {
+			return nil
+		}
+
+		err := app.start()
+		if err != nil {
+			pool.boots.release(pool.BootConcurrency)
+			app.closeLogFile()
+			pool.remove(app)
+			return errors.Context(err, "starting app")
+		}
+
+		return nil
+	})
+
+	return app, nil
+}
+
+// upstreamApp stands in for an app whose requests go to its upstream_url,
+// ready straight away as there's nothing to boot.
+func (pool *AppPool) upstreamApp(name, dir string, cfg AppConfig) *App {
+	app := &App{
+		Name:      name,
+		Events:    pool.Events,
+		Config:    cfg,
+		dir:       dir,
+		pool:      pool,
+		readyChan: make(chan struct{}),
+		lastUse:   time.Now(),
+	}
+
+	app.SetAddress(cfg.upstream.Scheme, cfg.upstream.Host, 0)
+
+	app.eventTryAdd("upstream_app", "destination", cfg.UpstreamURL)
+
+	fmt.Printf("* Sending requests for '%s' to %s\n", name, cfg.UpstreamURL)
+
+	app.t.Go(func() error {
+		<-app.t.Dying()
+		return nil
+	})
+
+	close(app.readyChan)
+
+	return app
+}
+
+// prepareCommand sets up, but doesn't start, the process that boots the
+// app.
+func (a *App) prepareCommand() error {
 	shell := os.Getenv("SHELL")
 
 	if shell == "" {
@@ -288,84 +691,203 @@ func (pool *AppPool) LaunchApp(name, dir string) (*App, error) {
 		shell = "/bin/bash"
 	}
 
-	cmd := exec.Command(shell, "-l", "-i", "-c",
-		fmt.Sprintf(executionShell, dir, name, socket, name, socket))
+	cmd := appCommand(shell, a.dir, a.Name, a.Address())
 
-	cmd.Dir = dir
+	cmd.Dir = a.dir
 
-	cmd.Env = os.Environ()
+	cmd.Env = a.baseEnv()
 	cmd.Env = append(cmd.Env,
 		fmt.Sprintf("THREADS=%d", DefaultThreads),
 		"WORKERS=0",
 		"CONFIG=-",
 	)
 
+	if a.Config.TTY {
+		return a.preparePty(cmd)
+	}
+
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	cmd.Stderr = cmd.Stdout
 
-	err = cmd.Start()
+	// The write end is closed by exec once the process exits, so all we
+	// need to do is hold on to it until then.
+	var stdin io.WriteCloser
+
+	if a.Config.Stdin == StdinPipe {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+	}
+
+	a.lock.Lock()
+	a.Command = cmd
+	a.stdout = stdout
+	a.stdin = stdin
+	a.lock.Unlock()
+
+	return nil
+}
+
+// preparePty connects cmd to a new pseudo-terminal, for apps that behave
+// differently without one.
+func (a *App) preparePty(cmd *exec.Cmd) error {
+	pty, tty, err := openPty()
 	if err != nil {
-		return nil, errors.Context(err, "starting app")
+		return errors.Context(err, "opening pty")
 	}
 
-	fmt.Printf("! Booting app '%s' on socket %s\n", name, socket)
+	err = setPtySize(tty)
+	if err != nil {
+		pty.Close()
+		tty.Close()
+		return errors.Context(err, "sizing pty")
+	}
 
-	app := &App{
-		Name:      name,
-		Command:   cmd,
-		Events:    pool.Events,
-		stdout:    stdout,
-		dir:       dir,
-		pool:      pool,
-		readyChan: make(chan struct{}),
-		lastUse:   time.Now(),
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	a.lock.Lock()
+	a.closePty()
+	a.Command = cmd
+	a.stdout = pty
+	a.stdin = nil
+	a.pty, a.tty = pty, tty
+	a.lock.Unlock()
+
+	return nil
+}
+
+// startCommand starts the prepared process. Called with a.lock held.
+func (a *App) startCommand() error {
+	err := a.Command.Start()
+
+	if a.tty != nil {
+		a.tty.Close()
+		a.tty = nil
 	}
 
-	app.eventAdd("booting_app", "socket", socket)
+	return err
+}
 
-	stat, err := os.Stat(filepath.Join(dir, "public"))
-	if err == nil {
-		app.Public = stat.IsDir()
+// closePty closes the pty of the previous process, if any. Called with
+// a.lock held.
+func (a *App) closePty() {
+	if a.pty != nil {
+		a.pty.Close()
+		a.pty = nil
 	}
 
-	app.SetAddress("httpu", socket, 0)
+	if a.tty != nil {
+		a.tty.Close()
+		a.tty = nil
+	}
+}
 
-	app.t.Go(app.watch)
-	app.t.Go(app.idleMonitor)
-	app.t.Go(app.restartMonitor)
+// start boots the app's process, holding the boot slot acquired for it until
+// the app is ready or dies.
+func (a *App) start() error {
+	a.lock.Lock()
+	err := a.startCommand()
+	a.lock.Unlock()
+
+	if err != nil {
+		return errors.Context(err, "starting app")
+	}
+
+	socket := a.Address()
+
+	fmt.Printf("! Booting app '%s' on socket %s\n", a.Name, socket)
+
+	a.eventTryAdd("booting_app", "socket", socket)
+
+	a.t.Go(a.watch)
+	a.t.Go(a.idleMonitor)
+	a.t.Go(a.restartMonitor)
+	a.t.Go(a.dirMonitor)
+
+	if a.Config.RestartOnBundleChange {
+		for _, name := range bundleFiles {
+			if _, err := os.Stat(filepath.Join(a.dir, name)); err != nil {
+				continue
+			}
+
+			name := name
+			a.t.Go(func() error {
+				return a.bundleMonitor(name)
+			})
+		}
+	}
+
+	a.t.Go(func() error {
+		defer a.pool.boots.release(a.pool.BootConcurrency)
 
-	app.t.Go(func() error {
 		// This is a poor substitute for getting an actual readiness signal
 		// from puma but it's good enough.
 
-		app.eventAdd("waiting_on_app")
+		a.eventAdd("waiting_on_app")
+
+		started := time.Now()
 
 		ticker := time.NewTicker(250 * time.Millisecond)
 		defer ticker.Stop()
 		for {
 			select {
-			case <-app.t.Dying():
-				app.eventAdd("dying_on_start")
-				fmt.Printf("! Detecting app '%s' dying on start\n", name)
+			case <-a.t.Dying():
+				a.eventAdd("dying_on_start")
+				fmt.Printf("! Detecting app '%s' dying on start\n", a.Name)
 				return fmt.Errorf("app died before booting")
 			case <-ticker.C:
 				c, err := net.Dial("unix", socket)
 				if err == nil {
 					c.Close()
-					app.eventAdd("app_ready")
-					fmt.Printf("! App '%s' booted\n", name)
-					close(app.readyChan)
+
+					if a.Config.HealthCheckPath != "" {
+						err = a.waitForHealthCheck()
+						if err != nil {
+							return err
+						}
+					}
+
+					a.eventAdd("app_ready")
+					fmt.Printf("! App '%s' booted\n", a.Name)
+					close(a.readyChan)
 					return nil
 				}
+
+				err = a.checkBootTimeouts(started)
+				if err != nil {
+					return err
+				}
 			}
 		}
 	})
 
-	return app, nil
+	return nil
+}
+
+// checkBootTimeouts returns an error once the app has been quiet for longer
+// than LaunchTimeout, or booting for longer than BootTimeout.
+func (a *App) checkBootTimeouts(started time.Time) error {
+	elapsed := time.Since(started)
+
+	if timeout := a.Config.LaunchTimeout; timeout > 0 && elapsed > timeout && a.lastLine() == "" {
+		a.eventAdd("launch_timeout", "timeout", timeout.String())
+		fmt.Printf("! App '%s' printed nothing within %s of launching\n", a.Name, timeout)
+		return fmt.Errorf("app didn't launch within %s", timeout)
+	}
+
+	if timeout := a.Config.BootTimeout; timeout > 0 && elapsed > timeout {
+		a.eventAdd("boot_timeout", "timeout", timeout.String())
+		fmt.Printf("! App '%s' didn't boot within %s\n", a.Name, timeout)
+		return fmt.Errorf("app didn't boot within %s", timeout)
+	}
+
+	return nil
 }
 
 func (pool *AppPool) readProxy(name, path string) (*App, error) {
@@ -374,57 +896,78 @@ func (pool *AppPool) readProxy(name, path string) (*App, error) {
 		return nil, err
 	}
 
+	cfg, err := LoadAppConfig(appConfigPath(path, false))
+	if err != nil {
+		return nil, err
+	}
+
 	app := &App{
 		Name:      name,
 		Events:    pool.Events,
+		Config:    cfg,
 		pool:      pool,
 		readyChan: make(chan struct{}),
 		lastUse:   time.Now(),
 	}
 
-	data = bytes.TrimSpace(data)
+	// Several backends, one per line, are balanced across.
+	entries := strings.Fields(string(data))
+	if len(entries) == 0 {
+		entries = []string{""}
+	}
 
-	port, err := strconv.Atoi(string(data))
-	if err == nil {
-		app.SetAddress("http", "127.0.0.1", port)
-	} else {
-		u, err := url.Parse(string(data))
+	var destinations []string
+
+	for _, entry := range entries {
+		b, err := parseBackend(entry)
 		if err != nil {
 			return nil, err
 		}
 
-		var (
-			sport, host string
-			port        int
-		)
+		if len(app.balancer.backends) > 0 && b.scheme != app.Scheme {
+			return nil, fmt.Errorf("proxy %s mixes %s and %s backends", name, app.Scheme, b.scheme)
+		}
 
-		host, sport, err = net.SplitHostPort(u.Host)
-		if err == nil {
-			port, err = strconv.Atoi(sport)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			host = u.Host
+		if len(app.balancer.backends) == 0 {
+			app.SetAddress(b.scheme, b.host, b.port)
 		}
 
-		app.SetAddress(u.Scheme, host, port)
+		app.balancer.backends = append(app.balancer.backends, b)
+		destinations = append(destinations, fmt.Sprintf("%s://%s", b.scheme, b.address()))
 	}
 
-	app.eventAdd("proxy_created",
-		"destination", fmt.Sprintf("%s://%s", app.Scheme, app.Address()))
+	app.eventTryAdd("proxy_created",
+		"destination", strings.Join(destinations, " "))
+
+	fmt.Printf("* Generated proxy connection for '%s' to %s\n",
+		name, strings.Join(destinations, ", "))
+
+	if cfg.HealthCheckPath == "" {
+		// to satisfy the tomb
+		app.t.Go(func() error {
+			<-app.t.Dying()
+			return nil
+		})
 
-	fmt.Printf("* Generated proxy connection for '%s' to %s://%s\n",
-		name, app.Scheme, app.Address())
+		close(app.readyChan)
+
+		return app, nil
+	}
 
-	// to satisfy the tomb
 	app.t.Go(func() error {
+		err := app.waitForHealthCheck()
+		if err != nil {
+			pool.remove(app)
+			return err
+		}
+
+		app.eventAdd("app_ready")
+		close(app.readyChan)
+
 		<-app.t.Dying()
 		return nil
 	})
 
-	close(app.readyChan)
-
 	return app, nil
 }
 
@@ -434,19 +977,28 @@ type AppPool struct {
 	Debug    bool
 	Events   *Events
 
+	// Zero means unlimited.
+	BootConcurrency int
+
+	// How often running apps check their directory still exists.
+	DirCheckInterval time.Duration
+
 	AppClosed func(*App)
 
-	lock sync.Mutex
-	apps map[string]*App
+	lock  sync.Mutex
+	apps  map[string]*App
+	boots bootQueue
 }
 
 func (a *AppPool) maybeIdle(app *App) bool {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
+	app.lock.Lock()
 	diff := time.Since(app.lastUse)
+	app.lock.Unlock()
 	if diff > a.IdleTime {
-		app.eventAdd("idle_app", "last_used", diff.String())
+		app.eventTryAdd("idle_app", "last_used", diff.String())
 		delete(a.apps, app.Name)
 		return true
 	}
@@ -454,10 +1006,14 @@ func (a *AppPool) maybeIdle(app *App) bool {
 	return false
 }
 
-var ErrUnknownApp = errors.New("unknown app")
+var (
+	ErrUnknownApp    = errors.New("unknown app")
+	ErrAppNotRunning = errors.New("app is not running")
+)
 
-// Find an app by domain name. If the app is not running, launch it.
-func (a *AppPool) lookupApp(name string) (*App, error) {
+// Find an app by domain name. If the app is not running, launch it, or
+// return ErrAppNotRunning when launch is false.
+func (a *AppPool) lookupApp(name string, launch bool) (*App, error) {
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
@@ -472,7 +1028,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 
 	path := filepath.Join(a.Dir, name)
 
-	a.Events.Add("app_lookup", "path", path)
+	a.Events.TryAdd("app_lookup", "path", path)
 
 	stat, err := os.Stat(path)
 	destPath, _ := os.Readlink(path)
@@ -486,7 +1042,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 		_, err := os.Lstat(path)
 		if err == nil {
 			fmt.Printf("! Bad symlink detected '%s'. Destination '%s' doesn't exist\n", path, destPath)
-			a.Events.Add("bad_symlink", "path", path, "dest", destPath)
+			a.Events.TryAdd("bad_symlink", "path", path, "dest", destPath)
 		}
 
 		// If possible, also try expanding - to / to allow for apps in subdirs
@@ -497,7 +1053,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 
 		path = filepath.Join(a.Dir, possible)
 
-		a.Events.Add("app_lookup", "path", path)
+		a.Events.TryAdd("app_lookup", "path", path)
 
 		stat, err = os.Stat(path)
 		destPath, _ = os.Readlink(path)
@@ -511,7 +1067,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 			_, err := os.Lstat(path)
 			if err == nil {
 				fmt.Printf("! Bad symlink detected '%s'. Destination '%s' doesn't exist\n", path, destPath)
-				a.Events.Add("bad_symlink", "path", path, "dest", destPath)
+				a.Events.TryAdd("bad_symlink", "path", path, "dest", destPath)
 			}
 
 			return nil, ErrUnknownApp
@@ -536,6 +1092,10 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 
 	app, ok = a.apps[canonicalName]
 
+	if !ok && !launch {
+		return nil, ErrAppNotRunning
+	}
+
 	if !ok {
 		if stat.IsDir() {
 			app, err = a.LaunchApp(canonicalName, path)
@@ -545,7 +1105,7 @@ func (a *AppPool) lookupApp(name string) (*App, error) {
 	}
 
 	if err != nil {
-		a.Events.Add("error_starting_app", "app", canonicalName, "error", err.Error())
+		a.Events.TryAdd("error_starting_app", "app", canonicalName, "error", err.Error())
 		return nil, err
 	}
 
@@ -568,33 +1128,54 @@ func pruneSub(name string) string {
 }
 
 func (a *AppPool) FindAppByDomainName(name string) (*App, error) {
+	app, _, err := a.FindAppWithSubdomain(name)
+	return app, err
+}
+
+// Also returns the labels stripped from name to find the app, e.g. "tenant1".
+func (a *AppPool) FindAppWithSubdomain(name string) (*App, string, error) {
+	return a.findApp(name, true)
+}
+
+// FindRunningApp works like FindAppByDomainName but never launches an app,
+// returning ErrAppNotRunning instead.
+func (a *AppPool) FindRunningApp(name string) (*App, error) {
+	app, _, err := a.findApp(name, false)
+	return app, err
+}
+
+func (a *AppPool) findApp(name string, launch bool) (*App, string, error) {
 	var (
 		app *App
 		err error
 	)
 
+	full := name
+
 	for name != "" {
-		app, err = a.lookupApp(name)
+		app, err = a.lookupApp(name, launch)
 		if err != nil {
 			if err == ErrUnknownApp {
 				name = pruneSub(name)
 				continue
 			}
 
-			return nil, err
+			return nil, "", err
 		}
 
 		break
 	}
 
 	if app == nil {
-		app, err = a.lookupApp("default")
+		app, err = a.lookupApp("default", launch)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
+
+		return app, "", nil
 	}
 
-	return app, nil
+	return app, strings.TrimSuffix(strings.TrimSuffix(full, name), "."), nil
 }
 
 func (a *AppPool) remove(app *App) {
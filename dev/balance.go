@@ -0,0 +1,153 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How requests are spread over a proxy app with several backends.
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceLeastConns = "least_conns"
+)
+
+// A backend that failed is skipped for this long.
+const backendDownCooldown = 10 * time.Second
+
+// backend is one of the addresses of a proxy app listing several.
+type backend struct {
+	scheme string
+	host   string
+	port   int
+
+	active    int
+	downUntil time.Time
+}
+
+func (b *backend) address() string {
+	if b.port == 0 {
+		return b.host
+	}
+
+	return fmt.Sprintf("%s:%d", b.host, b.port)
+}
+
+// parseBackend reads one entry of a proxy file, a port or a URL.
+func parseBackend(s string) (*backend, error) {
+	port, err := strconv.Atoi(s)
+	if err == nil {
+		return &backend{scheme: "http", host: "127.0.0.1", port: port}, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backend{scheme: u.Scheme}
+
+	host, sport, err := net.SplitHostPort(u.Host)
+	if err == nil {
+		b.port, err = strconv.Atoi(sport)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		host = u.Host
+	}
+
+	// Unix sockets are given as httpu:///path/to/socket.
+	if host == "" && u.Scheme == "httpu" {
+		host = u.Path
+	}
+
+	b.host = host
+
+	return b, nil
+}
+
+// balancer picks which of an app's backends gets each request.
+type balancer struct {
+	lock     sync.Mutex
+	backends []*backend
+	next     int
+}
+
+// pick returns the backend for the next request, nil if there's only one.
+// Backends that recently failed are skipped unless they all did.
+func (bl *balancer) pick(policy string) *backend {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	if len(bl.backends) < 2 {
+		return nil
+	}
+
+	now := time.Now()
+
+	var up []*backend
+
+	for i := range bl.backends {
+		b := bl.backends[(bl.next+i)%len(bl.backends)]
+		if !now.Before(b.downUntil) {
+			up = append(up, b)
+		}
+	}
+
+	if len(up) == 0 {
+		up = bl.backends
+	}
+
+	best := up[0]
+
+	if policy == BalanceLeastConns {
+		for _, b := range up[1:] {
+			if b.active < best.active {
+				best = b
+			}
+		}
+	}
+
+	for i, b := range bl.backends {
+		if b == best {
+			bl.next = i + 1
+		}
+	}
+
+	best.active++
+
+	return best
+}
+
+func (bl *balancer) done(b *backend) {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	b.active--
+}
+
+// failed marks b down, returning false if it already was.
+func (bl *balancer) failed(b *backend) bool {
+	bl.lock.Lock()
+	defer bl.lock.Unlock()
+
+	now := time.Now()
+	wasUp := !now.Before(b.downUntil)
+
+	b.downUntil = now.Add(backendDownCooldown)
+
+	return wasUp
+}
+
+// backendFailed takes a backend that couldn't be reached out of rotation.
+func (a *App) backendFailed(b *backend) {
+	if a.balancer.failed(b) {
+		a.eventAdd("backend_down",
+			"destination", fmt.Sprintf("%s://%s", b.scheme, b.address()),
+			"cooldown", backendDownCooldown.String())
+	}
+}
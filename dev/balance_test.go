@@ -0,0 +1,100 @@
+package dev
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBackend(t *testing.T) {
+	tests := []struct {
+		entry   string
+		scheme  string
+		address string
+	}{
+		{"9292", "http", "127.0.0.1:9292"},
+		{"https://10.3.1.2:9293", "https", "10.3.1.2:9293"},
+		{"http://example.test", "http", "example.test"},
+		{"httpu:///tmp/app.sock", "httpu", "/tmp/app.sock"},
+	}
+
+	for _, tt := range tests {
+		b, err := parseBackend(tt.entry)
+		if assert.NoError(t, err, tt.entry) {
+			assert.Equal(t, tt.scheme, b.scheme, tt.entry)
+			assert.Equal(t, tt.address, b.address(), tt.entry)
+		}
+	}
+}
+
+func TestHttp_balanceRoundRobin(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	one := namedBackend("one")
+	defer one.Close()
+
+	two := namedBackend("two")
+	defer two.Close()
+
+	linkTestProxyApp(t, h, "cluster", one.URL+"\n"+two.URL+"\n", "")
+
+	var names []string
+
+	for i := 0; i < 4; i++ {
+		rec := serveTestRequest(h, "GET", "http://cluster.test/")
+		names = append(names, strings.Fields(rec.Body.String())[0])
+	}
+
+	assert.Equal(t, []string{"one", "two", "one", "two"}, names)
+	assert.Contains(t, eventsString(h.Events), `"destination":"`+one.URL+" "+two.URL+`"`)
+}
+
+func TestHttp_balanceBackendDown(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	down := namedBackend("down")
+	down.Close()
+
+	up := namedBackend("up")
+	defer up.Close()
+
+	linkTestProxyApp(t, h, "cluster", down.URL+"\n"+up.URL+"\n", "")
+
+	rec := serveTestRequest(h, "GET", "http://cluster.test/")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+
+	for i := 0; i < 3; i++ {
+		rec = serveTestRequest(h, "GET", "http://cluster.test/")
+		assert.Equal(t, "up cluster.test ", rec.Body.String())
+	}
+
+	events := eventsString(h.Events)
+	assert.Contains(t, events, `"event":"backend_down","app":"cluster","destination":"`+down.URL+`","cooldown":"10s"`)
+	assert.Equal(t, 1, strings.Count(events, "backend_down"))
+}
+
+func TestBalancer_leastConns(t *testing.T) {
+	one := &backend{scheme: "http", host: "one"}
+	two := &backend{scheme: "http", host: "two"}
+	three := &backend{scheme: "http", host: "three"}
+
+	bl := &balancer{backends: []*backend{one, two, three}}
+
+	assert.Equal(t, one, bl.pick(BalanceLeastConns))
+	assert.Equal(t, two, bl.pick(BalanceLeastConns))
+	assert.Equal(t, three, bl.pick(BalanceLeastConns))
+
+	bl.done(two)
+	assert.Equal(t, two, bl.pick(BalanceLeastConns))
+
+	bl.failed(one)
+	bl.done(one)
+	assert.Equal(t, three, bl.pick(BalanceLeastConns))
+
+	single := &balancer{backends: []*backend{one}}
+	assert.Nil(t, single.pick(BalanceLeastConns))
+}
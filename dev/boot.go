@@ -0,0 +1,152 @@
+package dev
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// bootQueue bounds how many apps boot at once, highest priority first.
+type bootQueue struct {
+	lock    sync.Mutex
+	active  int
+	seq     uint64
+	waiters bootWaiters
+}
+
+type bootWaiter struct {
+	priority int
+	seq      uint64
+	index    int
+	ready    chan struct{}
+}
+
+type bootWaiters []*bootWaiter
+
+func (w bootWaiters) Len() int { return len(w) }
+
+func (w bootWaiters) Less(i, j int) bool {
+	if w[i].priority != w[j].priority {
+		return w[i].priority > w[j].priority
+	}
+
+	return w[i].seq < w[j].seq
+}
+
+func (w bootWaiters) Swap(i, j int) {
+	w[i], w[j] = w[j], w[i]
+	w[i].index = i
+	w[j].index = j
+}
+
+func (w *bootWaiters) Push(x interface{}) {
+	bw := x.(*bootWaiter)
+	bw.index = len(*w)
+	*w = append(*w, bw)
+}
+
+func (w *bootWaiters) Pop() interface{} {
+	old := *w
+	n := len(old)
+	bw := old[n-1]
+	old[n-1] = nil
+	bw.index = -1
+	*w = old[:n-1]
+	return bw
+}
+
+// tryAcquire takes a boot slot if one is free and nobody is waiting for
+// one. A max of zero or less means boots are unlimited.
+func (q *bootQueue) tryAcquire(max int) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.tryAcquireLocked(max)
+}
+
+func (q *bootQueue) tryAcquireLocked(max int) bool {
+	if max <= 0 || (q.active < max && len(q.waiters) == 0) {
+		q.active++
+		return true
+	}
+
+	return false
+}
+
+// acquire blocks until a boot slot is free, returning false if cancel is
+// closed first.
+func (q *bootQueue) acquire(max, priority int, cancel <-chan struct{}) bool {
+	q.lock.Lock()
+
+	if q.tryAcquireLocked(max) {
+		q.lock.Unlock()
+		return true
+	}
+
+	q.seq++
+
+	bw := &bootWaiter{
+		priority: priority,
+		seq:      q.seq,
+		ready:    make(chan struct{}),
+	}
+
+	heap.Push(&q.waiters, bw)
+
+	q.lock.Unlock()
+
+	select {
+	case <-bw.ready:
+		return true
+	case <-cancel:
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if bw.index < 0 {
+		// The slot was handed to us just as we gave up, so pass it on.
+		q.releaseLocked(max)
+	} else {
+		heap.Remove(&q.waiters, bw.index)
+	}
+
+	return false
+}
+
+func (q *bootQueue) release(max int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.releaseLocked(max)
+}
+
+func (q *bootQueue) releaseLocked(max int) {
+	q.active--
+
+	for len(q.waiters) > 0 && (max <= 0 || q.active < max) {
+		bw := heap.Pop(&q.waiters).(*bootWaiter)
+		q.active++
+		close(bw.ready)
+	}
+}
+
+// preboot boots the app for name ahead of its first request. Failing only
+// means the first request boots it instead.
+func (h *HTTPServer) preboot(name string) {
+	start := time.Now()
+
+	app, err := h.Pool.FindAppByDomainName(name)
+	if err == nil {
+		err = app.WaitTilReady()
+	}
+
+	if err != nil {
+		fmt.Printf("! Unable to preboot '%s': %s\n", name, err)
+		h.Events.Add("preboot_failed", "name", name, "error", err.Error())
+		return
+	}
+
+	h.Events.Add("preboot", "name", name, "app", app.Name, "duration", time.Since(start).String())
+}
@@ -0,0 +1,490 @@
+package dev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHelperApp is not a real test. It stands in for puma when tests swap
+// out appCommand with helperAppCommand.
+func TestHelperApp(t *testing.T) {
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+
+	if len(args) != 5 || args[1] != "helper-app" {
+		return
+	}
+
+	socket := args[2]
+
+	delay, err := time.ParseDuration(args[3])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	failures, err := strconv.Atoi(args[4])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	if failures > 0 {
+		counter := socket + ".launches"
+
+		data, _ := ioutil.ReadFile(counter)
+		launches, _ := strconv.Atoi(string(data))
+		launches++
+
+		ioutil.WriteFile(counter, []byte(strconv.Itoa(launches)), 0644)
+
+		if launches <= failures {
+			fmt.Printf("launch %d failed\n", launches)
+			os.Exit(1)
+		}
+	}
+
+	time.Sleep(delay)
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	stdinClosed := make(chan struct{})
+
+	go func() {
+		ioutil.ReadAll(os.Stdin)
+		close(stdinClosed)
+	}()
+
+	http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/env" {
+			w.Write([]byte(strings.Join(os.Environ(), "\n")))
+			return
+		}
+
+		if r.URL.Path == "/stdin" {
+			select {
+			case <-stdinClosed:
+				w.Write([]byte("closed"))
+			case <-time.After(100 * time.Millisecond):
+				w.Write([]byte("open"))
+			}
+
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+
+	os.Exit(0)
+}
+
+// helperAppCommand makes launched apps run TestHelperApp, which exits with
+// an error on its first failures launches and otherwise starts listening on
+// the app's socket after delay. Requests for /stdin get "open" or "closed"
+// depending on whether the app's stdin has hit EOF, and /env lists its
+// environment.
+func helperAppCommand(delay time.Duration, failures int) func() {
+	orig := appCommand
+
+	appCommand = func(shell, dir, name, socket string) *exec.Cmd {
+		return exec.Command(os.Args[0], "-test.run=^TestHelperApp$", "--",
+			"helper-app", socket, delay.String(), strconv.Itoa(failures))
+	}
+
+	return func() { appCommand = orig }
+}
+
+func makeTestApp(t *testing.T, h *HTTPServer, name, config string) {
+	dir := filepath.Join(h.Pool.Dir, name)
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, AppConfigFile), []byte(config), 0644); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+}
+
+func waitForEvent(t *testing.T, h *HTTPServer, event string) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !strings.Contains(eventsString(h.Events), event) {
+		if time.Now().After(deadline) {
+			assert.FailNow(t, "timed out waiting for "+event)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHttp_bootPriority(t *testing.T) {
+	defer helperAppCommand(500*time.Millisecond, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.Pool.BootConcurrency = 1
+
+	makeTestApp(t, h, "first", "")
+	makeTestApp(t, h, "background", "priority: 0\n")
+	makeTestApp(t, h, "current", "priority: 10\n")
+
+	var wg sync.WaitGroup
+
+	request := func(name string) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			rec := serveTestRequest(h, "GET", "http://"+name+".test/")
+			assert.Equal(t, "ok", rec.Body.String())
+		}()
+	}
+
+	request("first")
+	waitForEvent(t, h, `"event":"booting_app","app":"first"`)
+
+	request("background")
+	waitForEvent(t, h, `"event":"queued_for_boot","app":"background"`)
+
+	request("current")
+	waitForEvent(t, h, `"event":"queued_for_boot","app":"current"`)
+
+	wg.Wait()
+
+	events := eventsString(h.Events)
+
+	current := strings.Index(events, `"event":"booting_app","app":"current"`)
+	background := strings.Index(events, `"event":"booting_app","app":"background"`)
+
+	assert.True(t, current >= 0 && background >= 0)
+	assert.True(t, current < background, "expected current to boot before background")
+}
+
+func TestBootQueue_cancel(t *testing.T) {
+	var q bootQueue
+
+	assert.True(t, q.tryAcquire(1))
+	assert.False(t, q.tryAcquire(1))
+
+	cancel := make(chan struct{})
+	close(cancel)
+
+	assert.False(t, q.acquire(1, 0, cancel))
+
+	q.release(1)
+
+	assert.True(t, q.tryAcquire(1))
+}
+
+func TestHttp_prebootApps(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "")
+
+	h.PrebootApps = []string{"myapp", "missing"}
+	h.Setup()
+
+	assert.Eventually(t, func() bool {
+		events := eventsString(h.Events)
+
+		return strings.Contains(events, `"event":"preboot","name":"myapp"`) &&
+			strings.Contains(events, `"event":"preboot_failed","name":"missing","error":"unknown app"`)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	app, err := h.Pool.FindRunningApp("myapp")
+	if assert.NoError(t, err) {
+		assert.Equal(t, Running, app.Status())
+	}
+}
+
+func TestHttp_launchRetries(t *testing.T) {
+	defer helperAppCommand(0, 2)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "flaky", "launch_retries: 3\nlaunch_retry_backoff: 10ms\n")
+
+	rec := serveTestRequest(h, "GET", "http://flaky.test/")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+
+	events := eventsString(h.Events)
+	assert.Contains(t, events, `"event":"retrying_launch","app":"flaky","attempt":1,"delay":"10ms"`)
+	assert.Contains(t, events, `"event":"retrying_launch","app":"flaky","attempt":2,"delay":"20ms"`)
+	assert.NotContains(t, events, `"attempt":3`)
+}
+
+func TestHttp_launchRetries_exhausted(t *testing.T) {
+	defer helperAppCommand(0, 5)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "broken", "launch_retries: 1\nlaunch_retry_backoff: 10ms\n")
+
+	rec := serveTestRequest(h, "GET", "http://broken.test/")
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "launch 2 failed")
+
+	events := eventsString(h.Events)
+	assert.Contains(t, events, `"event":"retrying_launch","app":"broken","attempt":1`)
+	assert.NotContains(t, events, `"attempt":2`)
+}
+
+func TestApp_status(t *testing.T) {
+	app := &App{readyChan: make(chan struct{})}
+	assert.Equal(t, Booting, app.Status())
+
+	close(app.readyChan)
+	assert.Equal(t, Running, app.Status())
+
+	app.t.Kill(nil)
+	assert.Equal(t, Dead, app.Status())
+
+	app = &App{readyChan: make(chan struct{})}
+	app.t.Kill(nil)
+	assert.Equal(t, Dead, app.Status(), "killed before it was ready")
+}
+
+func TestHttp_statusFailedBoot(t *testing.T) {
+	defer helperAppCommand(0, 5)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "broken", "")
+
+	app, err := h.Pool.FindAppByDomainName("broken")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rec := serveTestRequest(h, "GET", "http://broken.test/")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	app.t.Wait()
+	assert.Equal(t, Dead, app.Status())
+	assert.Equal(t, "dead", statusName(app.Status()))
+}
+
+// Meant for -race: the app's process is replaced on every retry while
+// other requests look at and restart the app.
+func TestHttp_launchRetries_concurrentAccess(t *testing.T) {
+	defer helperAppCommand(0, 2)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "flaky", "launch_retries: 3\nlaunch_retry_backoff: 10ms\n")
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		serveTestRequest(h, "GET", "http://flaky.test/")
+	}()
+
+	waitForEvent(t, h, `"event":"booting_app","app":"flaky"`)
+
+	for i := 0; i < 20; i++ {
+		serveTestRequest(h, "GET", "http://puma-dev/status")
+		serveTestRequest(h, "POST", "http://puma-dev/apps/flaky/restart")
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	<-done
+}
+
+func TestHttp_stdin(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "quiet", "")
+	makeTestApp(t, h, "piped", "stdin: pipe\n")
+
+	rec := serveTestRequest(h, "GET", "http://quiet.test/stdin")
+	assert.Equal(t, "closed", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://piped.test/stdin")
+	assert.Equal(t, "open", rec.Body.String())
+}
+
+func TestHttp_cleanEnv(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	os.Setenv("PUMA_DEV_TEST_ALLOWED", "yes")
+	defer os.Unsetenv("PUMA_DEV_TEST_ALLOWED")
+
+	os.Setenv("PUMA_DEV_TEST_SECRET", "hunter2")
+	defer os.Unsetenv("PUMA_DEV_TEST_SECRET")
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "inherited", "")
+	makeTestApp(t, h, "isolated", "clean_env: true\nenv_allowlist:\n  - PUMA_DEV_TEST_ALLOWED\n  - PUMA_DEV_TEST_MISSING\n")
+
+	rec := serveTestRequest(h, "GET", "http://inherited.test/env")
+	assert.Contains(t, strings.Split(rec.Body.String(), "\n"), "PUMA_DEV_TEST_SECRET=hunter2")
+
+	rec = serveTestRequest(h, "GET", "http://isolated.test/env")
+
+	env := strings.Split(rec.Body.String(), "\n")
+	sort.Strings(env)
+
+	expected := []string{
+		"CONFIG=-",
+		"HOME=" + os.Getenv("HOME"),
+		"PATH=" + os.Getenv("PATH"),
+		"PUMA_DEV_TEST_ALLOWED=yes",
+		fmt.Sprintf("THREADS=%d", DefaultThreads),
+		"WORKERS=0",
+	}
+
+	assert.Equal(t, expected, env)
+}
+
+func TestHttp_appDirectoryMissing(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	for _, symlink := range []bool{false, true} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		h.Pool.DirCheckInterval = 50 * time.Millisecond
+
+		dir := filepath.Join(h.Pool.Dir, "doomed")
+
+		if symlink {
+			target, removeTarget := testTempDir(t)
+			defer removeTarget()
+
+			dir = filepath.Join(target, "doomed")
+			os.Mkdir(dir, 0755)
+			os.Symlink(dir, filepath.Join(h.Pool.Dir, "doomed"))
+		} else {
+			makeTestApp(t, h, "doomed", "")
+		}
+
+		rec := serveTestRequest(h, "GET", "http://doomed.test/")
+		assert.Equal(t, "ok", rec.Body.String())
+
+		os.RemoveAll(dir)
+
+		waitForEvent(t, h, `"event":"app_directory_missing"`)
+		waitForEvent(t, h, `"event":"shutdown"`)
+
+		count := 0
+		h.Pool.ForApps(func(*App) { count++ })
+		assert.Equal(t, 0, count, "symlink=%v", symlink)
+
+		rec = serveTestRequest(h, "GET", "http://doomed.test/")
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Contains(t, eventsString(h.Events), `"event":"unknown_app","name":"doomed"`)
+	}
+}
+
+func TestHttp_launchAndBootTimeouts(t *testing.T) {
+	command := func(name string, args ...string) func() {
+		orig := appCommand
+
+		appCommand = func(shell, dir, app, socket string) *exec.Cmd {
+			return exec.Command(name, args...)
+		}
+
+		return func() { appCommand = orig }
+	}
+
+	// Like helperAppCommand, but printing a line straight away.
+	chattyHelper := func(delay time.Duration) func() {
+		orig := appCommand
+
+		appCommand = func(shell, dir, app, socket string) *exec.Cmd {
+			return exec.Command("/bin/sh", "-c", `echo starting; exec "$@"`, "sh",
+				os.Args[0], "-test.run=^TestHelperApp$", "--", "helper-app", socket, delay.String(), "0")
+		}
+
+		return func() { appCommand = orig }
+	}
+
+	tests := []struct {
+		name     string
+		command  func() func()
+		config   string
+		expected string
+		within   time.Duration
+	}{
+		{
+			"missing command",
+			func() func() { return command("/nonexistent/puma") },
+			"launch_timeout: 500ms\nboot_timeout: 10s\n",
+			"no such file or directory",
+			time.Second,
+		},
+		{
+			"silent command",
+			func() func() { return command("sleep", "10") },
+			"launch_timeout: 500ms\nboot_timeout: 10s\n",
+			"app didn't launch within 500ms",
+			3 * time.Second,
+		},
+		{
+			"slow boot",
+			func() func() { return chattyHelper(time.Second) },
+			"launch_timeout: 500ms\nboot_timeout: 10s\n",
+			"ok",
+			5 * time.Second,
+		},
+		{
+			"boot timeout",
+			func() func() { return helperAppCommand(10*time.Second, 0) },
+			"boot_timeout: 500ms\n",
+			"app didn't boot within 500ms",
+			3 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.command()()
+
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			makeTestApp(t, h, "timeouts", tt.config)
+
+			start := time.Now()
+			rec := serveTestRequest(h, "GET", "http://timeouts.test/")
+
+			assert.Contains(t, rec.Body.String(), tt.expected)
+			assert.True(t, time.Since(start) < tt.within, "took %s", time.Since(start))
+		})
+	}
+}
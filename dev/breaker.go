@@ -0,0 +1,117 @@
+package dev
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCircuitBreakerWindow   = time.Minute
+	DefaultCircuitBreakerCooldown = 10 * time.Second
+)
+
+// circuitBreakers fast-fail requests to apps that keep failing to boot or
+// answer, keyed by the name requests are routed by.
+type circuitBreakers struct {
+	lock     sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures  []time.Time
+	openUntil time.Time
+}
+
+// openFor returns how much longer requests for name should be refused.
+func (cb *circuitBreakers) openFor(name string) time.Duration {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	c, ok := cb.circuits[name]
+	if !ok {
+		return 0
+	}
+
+	if left := time.Until(c.openUntil); left > 0 {
+		return left
+	}
+
+	return 0
+}
+
+// failure records a failed request for name, returning true if that opened
+// the circuit.
+func (cb *circuitBreakers) failure(name string, threshold int, window, cooldown time.Duration) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.circuits == nil {
+		cb.circuits = make(map[string]*circuit)
+	}
+
+	c, ok := cb.circuits[name]
+	if !ok {
+		c = &circuit{}
+		cb.circuits[name] = c
+	}
+
+	now := time.Now()
+
+	recent := c.failures[:0]
+	for _, t := range c.failures {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+
+	c.failures = append(recent, now)
+
+	if len(c.failures) < threshold {
+		return false
+	}
+
+	c.failures = nil
+	c.openUntil = now.Add(cooldown)
+
+	return true
+}
+
+func (cb *circuitBreakers) success(name string) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	delete(cb.circuits, name)
+}
+
+func (h *HTTPServer) circuitFailure(name string) {
+	if h.CircuitBreakerFailures <= 0 {
+		return
+	}
+
+	window := h.CircuitBreakerWindow
+	if window <= 0 {
+		window = DefaultCircuitBreakerWindow
+	}
+
+	cooldown := h.CircuitBreakerCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultCircuitBreakerCooldown
+	}
+
+	if h.breakers.failure(name, h.CircuitBreakerFailures, window, cooldown) {
+		h.Events.Add("circuit_open", "app", name, "cooldown", cooldown.String())
+		fmt.Printf("! App '%s' keeps failing, refusing requests for %s\n", name, cooldown)
+	}
+}
+
+func (h *HTTPServer) circuitSuccess(req *http.Request) {
+	if h.CircuitBreakerFailures <= 0 {
+		return
+	}
+
+	if name, ok := req.Context().Value(circuitContextKey).(string); ok {
+		h.breakers.success(name)
+	}
+}
@@ -0,0 +1,108 @@
+package dev
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreakers(t *testing.T) {
+	var cb circuitBreakers
+
+	assert.False(t, cb.failure("myapp", 2, time.Minute, time.Minute))
+	cb.success("myapp")
+	assert.False(t, cb.failure("myapp", 2, time.Minute, time.Minute))
+	assert.Zero(t, cb.openFor("myapp"))
+
+	assert.True(t, cb.failure("myapp", 2, time.Minute, time.Minute))
+	assert.True(t, cb.openFor("myapp") > 0)
+	assert.Zero(t, cb.openFor("other"))
+
+	// Failures further apart than the window don't add up.
+	assert.False(t, cb.failure("slow", 2, 10*time.Millisecond, time.Minute))
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, cb.failure("slow", 2, 10*time.Millisecond, time.Minute))
+}
+
+func TestHttp_circuitBreaker(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.CircuitBreakerFailures = 2
+	h.CircuitBreakerCooldown = 100 * time.Millisecond
+
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+
+	linkTestProxyApp(t, h, "broken", backend.URL, "")
+
+	for i := 0; i < 2; i++ {
+		rec := serveTestRequest(h, "GET", "http://broken.test/")
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+	}
+
+	assert.Contains(t, eventsString(h.Events), `"event":"circuit_open","app":"broken","cooldown":"100ms"`)
+
+	rec := serveTestRequest(h, "GET", "http://broken.test/")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "app 'broken' keeps failing")
+
+	time.Sleep(150 * time.Millisecond)
+
+	rec = serveTestRequest(h, "GET", "http://broken.test/")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestHttp_circuitBreaker_bootFailures(t *testing.T) {
+	defer helperAppCommand(0, 5)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.CircuitBreakerFailures = 1
+
+	makeTestApp(t, h, "crashing", "")
+
+	rec := serveTestRequest(h, "GET", "http://crashing.test/")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	rec = serveTestRequest(h, "GET", "http://crashing.test/")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, 1, strings.Count(eventsString(h.Events), `"event":"booting_app"`))
+}
+
+func TestHttp_circuitBreaker_clientGone(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.CircuitBreakerFailures = 1
+
+	backend := slowBackend(200 * time.Millisecond)
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "slow", backend.URL, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	req := httptest.NewRequest("GET", "http://slow.test/", nil).WithContext(ctx)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	h.RequestTimeout = 50 * time.Millisecond
+
+	rec := serveTestRequest(h, "GET", "http://slow.test/")
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+
+	h.RequestTimeout = 0
+
+	rec = serveTestRequest(h, "GET", "http://slow.test/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "done", rec.Body.String())
+	assert.NotContains(t, eventsString(h.Events), "circuit_open")
+}
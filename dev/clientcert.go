@@ -0,0 +1,81 @@
+package dev
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/vektra/errors"
+)
+
+// clientCertHeaders are set from the client's TLS certificate, and always
+// removed from requests so clients can't supply them themselves.
+var clientCertHeaders = []string{
+	"X-Client-Cert-Subject",
+	"X-Client-Cert-Issuer",
+	"X-Client-Cert-Verified",
+}
+
+// configureClientCerts makes the TLS listener ask clients for a certificate
+// when ClientCertCAFile is set. Clients without one are still accepted.
+func (h *HTTPServer) configureClientCerts(tlsConfig *tls.Config) error {
+	if h.ClientCertCAFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(h.ClientCertCAFile)
+	if err != nil {
+		return errors.Context(err, "reading client certificate CAs")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in %s", h.ClientCertCAFile)
+	}
+
+	h.clientCAs = pool
+
+	tlsConfig.ClientAuth = tls.RequestClientCert
+
+	return nil
+}
+
+// setClientCertHeaders describes the certificate the client presented, if
+// any, the way an mTLS-terminating load balancer would.
+func (h *HTTPServer) setClientCertHeaders(req *http.Request) {
+	for _, name := range clientCertHeaders {
+		req.Header.Del(name)
+	}
+
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+
+	req.Header.Set("X-Client-Cert-Subject", cert.Subject.String())
+	req.Header.Set("X-Client-Cert-Issuer", cert.Issuer.String())
+
+	verified := "FAILED"
+
+	if h.clientCAs != nil {
+		intermediates := x509.NewCertPool()
+		for _, c := range req.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(c)
+		}
+
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         h.clientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+
+		if err == nil {
+			verified = "SUCCESS"
+		}
+	}
+
+	req.Header.Set("X-Client-Cert-Verified", verified)
+}
@@ -0,0 +1,133 @@
+package dev
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	return cert, key
+}
+
+func clientCertRequest(h *HTTPServer, certs ...*x509.Certificate) map[string]string {
+	req := httptest.NewRequest("GET", "https://myapp.test/", nil)
+	req.Header.Set("X-Client-Cert-Verified", "SUCCESS")
+
+	if certs != nil {
+		req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	var headers map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &headers)
+
+	return headers
+}
+
+func TestHttp_clientCertHeaders(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	ca, caKey := makeTestCert(t, "Test CA", nil, nil)
+	client, _ := makeTestCert(t, "alice", ca, caKey)
+	stranger, _ := makeTestCert(t, "mallory", nil, nil)
+
+	dir, removeDir := testTempDir(t)
+	defer removeDir()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644)
+
+	h.ClientCertCAFile = caFile
+
+	var tlsConfig tls.Config
+	assert.NoError(t, h.configureClientCerts(&tlsConfig))
+	assert.Equal(t, tls.RequestClientCert, tlsConfig.ClientAuth)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := map[string]string{}
+		for _, name := range clientCertHeaders {
+			if v := r.Header.Get(name); v != "" {
+				headers[name] = v
+			}
+		}
+
+		json.NewEncoder(w).Encode(headers)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	headers := clientCertRequest(h, client, ca)
+	assert.Equal(t, "CN=alice", headers["X-Client-Cert-Subject"])
+	assert.Equal(t, "CN=Test CA", headers["X-Client-Cert-Issuer"])
+	assert.Equal(t, "SUCCESS", headers["X-Client-Cert-Verified"])
+
+	headers = clientCertRequest(h, stranger)
+	assert.Equal(t, "CN=mallory", headers["X-Client-Cert-Subject"])
+	assert.Equal(t, "FAILED", headers["X-Client-Cert-Verified"])
+
+	headers = clientCertRequest(h)
+	assert.Empty(t, headers)
+}
+
+func TestHttp_configureClientCerts_badFile(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(path, []byte("not a certificate"), 0644)
+
+	h := &HTTPServer{ClientCertCAFile: path}
+
+	var tlsConfig tls.Config
+	assert.Error(t, h.configureClientCerts(&tlsConfig))
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+}
@@ -0,0 +1,162 @@
+package dev
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Request headers that can change a response, so requests only share one
+// when these match too.
+var coalesceVaryHeaders = []string{"Accept", "Accept-Encoding", "Authorization", "Cookie"}
+
+// coalescer lets identical GETs that arrive while one is in flight share its
+// response instead of each going to the app.
+type coalescer struct {
+	lock     sync.Mutex
+	inflight map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done   chan struct{}
+	resp   bufferedResponse
+	shared bool
+}
+
+// coalesceKey returns what identifies req's response, false if req mustn't
+// share one.
+func (h *HTTPServer) coalesceKey(req *http.Request) (string, bool) {
+	if req.Method != "GET" || req.ContentLength != 0 || req.Header.Get("Range") != "" ||
+		req.Header.Get("Upgrade") != "" || h.isStreamingPath(req.URL.Path) ||
+		strings.Contains(req.Header.Get("Accept"), "text/event-stream") {
+		return "", false
+	}
+
+	var key strings.Builder
+
+	key.WriteString(req.Host)
+	key.WriteString(req.URL.RequestURI())
+
+	for _, name := range coalesceVaryHeaders {
+		key.WriteString("\n" + name + ": " + strings.Join(req.Header.Values(name), ", "))
+	}
+
+	return key.String(), true
+}
+
+// serve passes req to next, unless an identical request is already in
+// flight, in which case its response is copied to w once it's done.
+func (c *coalescer) serve(h *HTTPServer, w http.ResponseWriter, req *http.Request, next http.Handler) {
+	key, ok := h.coalesceKey(req)
+	if !ok {
+		next.ServeHTTP(w, req)
+		return
+	}
+
+	c.lock.Lock()
+
+	if c.inflight == nil {
+		c.inflight = make(map[string]*coalescedCall)
+	}
+
+	call, joined := c.inflight[key]
+	if !joined {
+		call = &coalescedCall{done: make(chan struct{})}
+		c.inflight[key] = call
+	}
+
+	c.lock.Unlock()
+
+	if joined {
+		select {
+		case <-call.done:
+		case <-req.Context().Done():
+			return
+		}
+
+		if !call.shared {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		h.Events.Add("request_coalesced", "host", req.Host, "path", req.URL.Path)
+
+		call.resp.writeTo(w)
+		return
+	}
+
+	c.lead(call, key, req, next)
+
+	call.resp.writeTo(w)
+}
+
+// lead gets call's response from next, releasing the requests waiting on it
+// even if next panics, as the proxy does when an app's response is cut off.
+func (c *coalescer) lead(call *coalescedCall, key string, req *http.Request, next http.Handler) {
+	defer func() {
+		c.lock.Lock()
+		delete(c.inflight, key)
+		c.lock.Unlock()
+
+		close(call.done)
+	}()
+
+	next.ServeHTTP(&call.resp, req)
+
+	call.shared = call.resp.shareable()
+}
+
+// bufferedResponse holds a response in full so it can be written out more
+// than once.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (br *bufferedResponse) Header() http.Header {
+	if br.header == nil {
+		br.header = make(http.Header)
+	}
+
+	return br.header
+}
+
+func (br *bufferedResponse) WriteHeader(status int) {
+	if br.status == 0 {
+		br.status = status
+	}
+}
+
+func (br *bufferedResponse) Write(b []byte) (int, error) {
+	br.WriteHeader(http.StatusOK)
+
+	return br.body.Write(b)
+}
+
+// shareable reports whether the response may go to clients other than the
+// one that asked for it.
+func (br *bufferedResponse) shareable() bool {
+	if br.Header().Get("Set-Cookie") != "" {
+		return false
+	}
+
+	cc := strings.ToLower(br.Header().Get("Cache-Control"))
+
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+func (br *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range br.Header() {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+
+	status := br.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+	w.Write(br.body.Bytes())
+}
@@ -0,0 +1,130 @@
+package dev
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingBackend answers slowly with how many requests it has had.
+func countingBackend(header http.Header) (*httptest.Server, *int32) {
+	var hits int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&hits, 1)
+
+		time.Sleep(200 * time.Millisecond)
+
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+
+		w.Header().Set("X-Hit", fmt.Sprint(n))
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.RequestURI())
+	}))
+
+	return backend, &hits
+}
+
+func concurrentRequests(h *HTTPServer, n int, build func(i int) *http.Request) []*httptest.ResponseRecorder {
+	recs := make([]*httptest.ResponseRecorder, n)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			recs[i] = httptest.NewRecorder()
+			h.ServeHTTP(recs[i], build(i))
+		}(i)
+	}
+
+	wg.Wait()
+
+	return recs
+}
+
+func TestHttp_coalesceRequests(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.CoalesceRequests = true
+
+	backend, hits := countingBackend(http.Header{"Cache-Control": {"max-age=60"}})
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	recs := concurrentRequests(h, 5, func(i int) *http.Request {
+		return httptest.NewRequest("GET", "http://myapp.test/page?a=1", nil)
+	})
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(hits))
+
+	for _, rec := range recs {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "GET /page?a=1", rec.Body.String())
+		assert.Equal(t, "1", rec.Header().Get("X-Hit"))
+		assert.Equal(t, "max-age=60", rec.Header().Get("Cache-Control"))
+	}
+
+	assert.Equal(t, 4, strings.Count(eventsString(h.Events), `"event":"request_coalesced","host":"myapp.test","path":"/page"`))
+
+	atomic.StoreInt32(hits, 0)
+
+	concurrentRequests(h, 3, func(i int) *http.Request {
+		req := httptest.NewRequest("GET", "http://myapp.test/page", nil)
+		req.Header.Set("Cookie", fmt.Sprintf("session=%d", i))
+		return req
+	})
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(hits), "different cookies")
+
+	atomic.StoreInt32(hits, 0)
+
+	concurrentRequests(h, 3, func(i int) *http.Request {
+		return httptest.NewRequest("POST", "http://myapp.test/page", nil)
+	})
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(hits), "POST")
+}
+
+func TestHttp_coalesceRequests_notShareable(t *testing.T) {
+	for _, header := range []http.Header{
+		{"Set-Cookie": {"session=abc"}},
+		{"Cache-Control": {"private"}},
+		{"Cache-Control": {"no-store"}},
+	} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		h.CoalesceRequests = true
+
+		backend, hits := countingBackend(header)
+		defer backend.Close()
+
+		linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+		recs := concurrentRequests(h, 3, func(i int) *http.Request {
+			return httptest.NewRequest("GET", "http://myapp.test/account", nil)
+		})
+
+		assert.Equal(t, int32(3), atomic.LoadInt32(hits), fmt.Sprint(header))
+
+		for _, rec := range recs {
+			assert.Equal(t, "GET /account", rec.Body.String())
+		}
+
+		assert.NotContains(t, eventsString(h.Events), "request_coalesced")
+	}
+}
@@ -0,0 +1,369 @@
+package dev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vektra/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Optional per-app config, in the app's root or as .<name>.yml next to a proxy file.
+const AppConfigFile = ".puma-dev.yml"
+
+const DefaultLaunchRetryBackoff = 1 * time.Second
+
+// DefaultPublicDir is where static files are served from without public_dir.
+const DefaultPublicDir = "public"
+
+// What an app's stdin is connected to.
+const (
+	StdinNull = "null"
+	StdinPipe = "pipe"
+)
+
+type AppConfig struct {
+	// Zero means unlimited.
+	MaxConcurrency int `yaml:"max_concurrency"`
+	QueueSize      int `yaml:"queue_size"`
+
+	// Tune the concurrency limit, up to MaxConcurrency, to meet LatencyTarget.
+	AdaptiveConcurrency bool          `yaml:"adaptive_concurrency"`
+	LatencyTarget       time.Duration `yaml:"latency_target"`
+
+	// The app is ready once HealthCheckPath returns a 200.
+	HealthCheckPath        string        `yaml:"health_check_path"`
+	HealthCheckTimeout     time.Duration `yaml:"health_check_timeout"`
+	HealthCheckMaxFailures int           `yaml:"health_check_max_failures"`
+
+	// Higher boots first.
+	Priority int `yaml:"priority"`
+
+	// LaunchTimeout is how long the process has to print anything and
+	// BootTimeout how long it has to start listening. Zero means no limit.
+	LaunchTimeout time.Duration `yaml:"launch_timeout"`
+	BootTimeout   time.Duration `yaml:"boot_timeout"`
+
+	// The backoff doubles with every retry.
+	LaunchRetries      int           `yaml:"launch_retries"`
+	LaunchRetryBackoff time.Duration `yaml:"launch_retry_backoff"`
+
+	// Send requests to this http(s) URL instead of booting the app.
+	UpstreamURL string `yaml:"upstream_url"`
+
+	// Sent to the app as the Host header in place of the client's, which
+	// goes along as X-Forwarded-Host.
+	UpstreamHost string `yaml:"upstream_host"`
+
+	BodySizeRoutes []BodySizeRoute `yaml:"body_size_routes"`
+
+	// Applied to response bodies in order, which buffers them in full.
+	BodyReplacements []BodyReplacement `yaml:"body_replacements"`
+
+	// How a proxy app with several backends spreads requests over them,
+	// BalanceRoundRobin unless set.
+	Balance string `yaml:"balance"`
+
+	// Give the app its own upstream connection pool of this size.
+	UpstreamMaxIdleConns int `yaml:"upstream_max_idle_conns"`
+	UpstreamMaxConns     int `yaml:"upstream_max_conns"`
+
+	// Speak HTTP/2 without TLS (h2c) to the app.
+	H2C bool `yaml:"h2c"`
+
+	DisableWebSocketExtensions bool `yaml:"disable_websocket_extensions"`
+
+	// Relative to the app's directory, rotated past LogMaxSize bytes.
+	LogFile     string `yaml:"log_file"`
+	LogMaxSize  int64  `yaml:"log_max_size"`
+	LogMaxFiles int    `yaml:"log_max_files"`
+
+	Stdin string `yaml:"stdin"`
+
+	// Run the app on a pseudo-terminal, as its stdin, stdout and stderr.
+	TTY bool `yaml:"tty"`
+
+	CleanEnv     bool     `yaml:"clean_env"`
+	EnvAllowlist []string `yaml:"env_allowlist"`
+
+	StripPrefix string `yaml:"strip_prefix"`
+
+	// Static files are served from this subdirectory, DefaultPublicDir if unset.
+	PublicDir string `yaml:"public_dir"`
+
+	// Entries starting with "*." match any subdomain.
+	AllowedHosts []string `yaml:"allowed_hosts"`
+
+	RestartOnBundleChange bool `yaml:"restart_on_bundle_change"`
+
+	NormalizeQuery bool `yaml:"normalize_query"`
+
+	// Reported in /status.
+	MetricsLabels map[string]string `yaml:"metrics_labels"`
+
+	// Header names passed on spelled as given instead of canonicalized.
+	PreserveHeaderCase []string `yaml:"preserve_header_case"`
+
+	// Log the headers of requests to the app, as sent after any rewrites,
+	// and of its responses. Values of DefaultRedactedHeaders and
+	// RedactHeaders are hidden.
+	LogHeaders    bool     `yaml:"log_headers"`
+	RedactHeaders []string `yaml:"redact_headers"`
+
+	upstream *url.URL
+}
+
+type BodySizeRoute struct {
+	Over     int64  `yaml:"over"`
+	Upstream string `yaml:"upstream"`
+
+	upstream *url.URL
+}
+
+type BodyReplacement struct {
+	Find    string `yaml:"find"`
+	Replace string `yaml:"replace"`
+
+	// Defaults to text/html.
+	ContentTypes []string `yaml:"content_types"`
+}
+
+// appliesTo reports whether responses of ctype should have the replacement
+// made.
+func (r *BodyReplacement) appliesTo(ctype string) bool {
+	if len(r.ContentTypes) == 0 {
+		return ctype == "text/html"
+	}
+
+	for _, t := range r.ContentTypes {
+		if strings.EqualFold(t, ctype) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bodySizeUpstream returns the route with the highest threshold under
+// length, or nil to use the app.
+func (cfg *AppConfig) bodySizeUpstream(length int64) *url.URL {
+	var best *BodySizeRoute
+
+	for i, route := range cfg.BodySizeRoutes {
+		if length > route.Over && (best == nil || route.Over > best.Over) {
+			best = &cfg.BodySizeRoutes[i]
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	return best.upstream
+}
+
+func (cfg *AppConfig) publicDir() string {
+	if cfg.PublicDir == "" {
+		return DefaultPublicDir
+	}
+
+	return cfg.PublicDir
+}
+
+// stripPathPrefix returns p without StripPrefix, if p is under it.
+func (cfg *AppConfig) stripPathPrefix(p string) (string, bool) {
+	if cfg.StripPrefix == "" || !strings.HasPrefix(p, cfg.StripPrefix) {
+		return p, false
+	}
+
+	rest := p[len(cfg.StripPrefix):]
+
+	switch {
+	case rest == "":
+		return "/", true
+	case rest[0] == '/':
+		return rest, true
+	default:
+		return p, false
+	}
+}
+
+// redactsHeader reports whether log_headers hides the value of header name.
+func (cfg *AppConfig) redactsHeader(name string) bool {
+	for _, redacted := range DefaultRedactedHeaders {
+		if strings.EqualFold(name, redacted) {
+			return true
+		}
+	}
+
+	for _, redacted := range cfg.RedactHeaders {
+		if strings.EqualFold(name, redacted) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowsHost reports whether requests for host may reach the app.
+func (cfg *AppConfig) allowsHost(host string) bool {
+	if len(cfg.AllowedHosts) == 0 {
+		return true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+
+	for _, allowed := range cfg.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+
+		if strings.HasPrefix(allowed, "*.") {
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeQuery sorts rawQuery by name and drops duplicate pairs.
+func normalizeQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+
+	for name, vals := range values {
+		seen := make(map[string]bool, len(vals))
+		unique := vals[:0]
+
+		for _, v := range vals {
+			if !seen[v] {
+				seen[v] = true
+				unique = append(unique, v)
+			}
+		}
+
+		values[name] = unique
+	}
+
+	return values.Encode()
+}
+
+// recaseHeaders respells the canonical keys of header as given in names.
+func recaseHeaders(header http.Header, names []string) {
+	for _, name := range names {
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical == name {
+			continue
+		}
+
+		if vals, ok := header[canonical]; ok {
+			delete(header, canonical)
+			header[name] = vals
+		}
+	}
+}
+
+func appConfigPath(path string, isDir bool) string {
+	if isDir {
+		return filepath.Join(path, AppConfigFile)
+	}
+
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".yml")
+}
+
+// A missing config file results in the zero config.
+func LoadAppConfig(path string) (AppConfig, error) {
+	var cfg AppConfig
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+
+		return cfg, err
+	}
+
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		return cfg, errors.Context(err, "parsing "+path)
+	}
+
+	switch cfg.Stdin {
+	case "", StdinNull, StdinPipe:
+	default:
+		return cfg, fmt.Errorf("invalid stdin '%s' in %s, must be %s or %s", cfg.Stdin, path, StdinNull, StdinPipe)
+	}
+
+	switch cfg.Balance {
+	case "", BalanceRoundRobin, BalanceLeastConns:
+	default:
+		return cfg, fmt.Errorf("invalid balance '%s' in %s, must be %s or %s", cfg.Balance, path, BalanceRoundRobin, BalanceLeastConns)
+	}
+
+	if cfg.TTY && cfg.Stdin == StdinPipe {
+		return cfg, fmt.Errorf("invalid stdin '%s' in %s, tty apps read from their terminal", cfg.Stdin, path)
+	}
+
+	if cfg.StripPrefix != "" && !strings.HasPrefix(cfg.StripPrefix, "/") {
+		return cfg, fmt.Errorf("invalid strip_prefix '%s' in %s, must start with /", cfg.StripPrefix, path)
+	}
+
+	cfg.StripPrefix = strings.TrimSuffix(cfg.StripPrefix, "/")
+
+	if cfg.PublicDir != "" {
+		publicDir := filepath.Clean(filepath.FromSlash(cfg.PublicDir))
+		if filepath.IsAbs(publicDir) || publicDir == "." || publicDir == ".." ||
+			strings.HasPrefix(publicDir, ".."+string(filepath.Separator)) {
+			return cfg, fmt.Errorf("invalid public_dir '%s' in %s, must be a subdirectory of the app", cfg.PublicDir, path)
+		}
+
+		cfg.PublicDir = publicDir
+	}
+
+	if cfg.UpstreamURL != "" {
+		u, err := url.Parse(cfg.UpstreamURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			(u.Path != "" && u.Path != "/") {
+			return cfg, fmt.Errorf("invalid upstream_url '%s' in %s, must be an http(s) URL without a path", cfg.UpstreamURL, path)
+		}
+
+		cfg.upstream = u
+	}
+
+	if strings.ContainsAny(cfg.UpstreamHost, "/ ") {
+		return cfg, fmt.Errorf("invalid upstream_host '%s' in %s, must be a host, optionally with a port", cfg.UpstreamHost, path)
+	}
+
+	for i, route := range cfg.BodySizeRoutes {
+		u, err := url.Parse(route.Upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cfg, fmt.Errorf("invalid body_size_routes upstream '%s' in %s", route.Upstream, path)
+		}
+
+		cfg.BodySizeRoutes[i].upstream = u
+	}
+
+	for _, r := range cfg.BodyReplacements {
+		if r.Find == "" {
+			return cfg, fmt.Errorf("invalid body_replacements entry in %s, find can't be empty", path)
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,224 @@
+package dev
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAppConfig_missing(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	cfg, err := LoadAppConfig(filepath.Join(dir, AppConfigFile))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cfg.MaxConcurrency)
+}
+
+func TestLoadAppConfig_invalid(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, AppConfigFile)
+
+	for _, config := range []string{
+		"body_size_routes:\n  - over: 10\n    upstream: localhost:4000\n",
+		"stdin: tty\n",
+		"balance: random\n",
+		"upstream_url: staging.example.com\n",
+		"upstream_url: https://staging.example.com/api\n",
+		"upstream_host: http://internal.test\n",
+		"tty: true\nstdin: pipe\n",
+		"body_replacements:\n  - replace: x\n",
+		"public_dir: ../other\n",
+		"public_dir: /etc\n",
+		"public_dir: .\n",
+		"strip_prefix: admin\n",
+	} {
+		ioutil.WriteFile(path, []byte(config), 0644)
+
+		_, err := LoadAppConfig(path)
+		assert.Error(t, err, config)
+	}
+}
+
+func namedBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(name + " " + r.Host + " " + string(body)))
+	}))
+}
+
+func TestHttp_bodySizeRoutes(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	app := namedBackend("app")
+	defer app.Close()
+
+	medium := namedBackend("medium")
+	defer medium.Close()
+
+	large := namedBackend("large")
+	defer large.Close()
+
+	linkTestProxyApp(t, h, "uploads", app.URL,
+		"body_size_routes:\n"+
+			"  - over: 100\n    upstream: "+large.URL+"\n"+
+			"  - over: 10\n    upstream: "+medium.URL+"\n")
+
+	post := func(body string, chunked bool) string {
+		req := httptest.NewRequest("POST", "http://uploads.test/upload", strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return strings.SplitN(rec.Body.String(), " ", 2)[0]
+	}
+
+	assert.Equal(t, "app", post("small", false))
+	assert.Equal(t, "app", post(strings.Repeat("x", 10), false))
+	assert.Equal(t, "medium", post(strings.Repeat("x", 11), false))
+	assert.Equal(t, "large", post(strings.Repeat("x", 101), false))
+	assert.Equal(t, "app", post(strings.Repeat("x", 101), true))
+}
+
+func TestHttp_upstreamURL(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+
+	h.JSONLogging = true
+	h.logOutput = &out
+
+	staging := namedBackend("staging")
+	defer staging.Close()
+
+	makeTestApp(t, h, "frontend", "upstream_url: "+staging.URL+"\n")
+
+	rec := serveTestRequest(h, "GET", "http://frontend.test/")
+
+	assert.Equal(t, "staging "+strings.TrimPrefix(staging.URL, "http://")+" ", rec.Body.String())
+	assert.Contains(t, out.String(), `"resolved_app":"frontend","mode":"upstream"`)
+	assert.Contains(t, eventsString(h.Events), `"event":"upstream_app","app":"frontend","destination":"`+staging.URL+`"`)
+	assert.NotContains(t, eventsString(h.Events), "booting_app")
+}
+
+func TestHttp_upstreamHost(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.Redirect(w, r, "http://"+r.Host+"/session", http.StatusFound)
+			return
+		}
+
+		w.Write([]byte(r.Host + " " + r.Header.Get("X-Forwarded-Host")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "upstream_host: myapp.internal:3000\n")
+	linkTestProxyApp(t, h, "plain", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "myapp.internal:3000 myapp.test", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/login")
+	assert.Equal(t, "http://myapp.test/session", rec.Header().Get("Location"))
+
+	rec = serveTestRequest(h, "GET", "http://plain.test/")
+	assert.Equal(t, "plain.test ", rec.Body.String())
+}
+
+func TestHttp_stripPrefix(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Forwarded-Prefix")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "strip_prefix: /admin/\n")
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "strip_prefix: /services\n")
+
+	cases := map[string]string{
+		"http://myapp.test/admin/users?page=2":          "/users /admin",
+		"http://myapp.test/admin":                       "/ /admin",
+		"http://myapp.test/administrators":              "/administrators ",
+		"http://myapp.test/users":                       "/users ",
+		"http://api.pco.test/services/v2/people":        "/v2/people /services",
+		"http://services.pco.test/services/v2/songs/12": "/v2/songs/12 /services",
+	}
+
+	for url, expected := range cases {
+		rec := serveTestRequest(h, "GET", url)
+		assert.Equal(t, expected, rec.Body.String(), url)
+	}
+}
+
+func TestHttp_allowedHosts(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := namedBackend("default")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "default", backend.URL, "allowed_hosts:\n  - default.test\n  - \"*.default.test\"\n")
+
+	for _, host := range []string{"default.test", "default.test:8080", "api.default.test"} {
+		rec := serveTestRequest(h, "GET", "http://"+host+"/")
+		assert.Equal(t, http.StatusOK, rec.Code, host)
+	}
+
+	for _, host := range []string{"other.test", "notdefault.test"} {
+		rec := serveTestRequest(h, "GET", "http://"+host+"/")
+		assert.Equal(t, http.StatusForbidden, rec.Code, host)
+	}
+
+	assert.Contains(t, eventsString(h.Events), `"event":"host_rejected","app":"default","host":"other.test"`)
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	cases := map[string]string{
+		"b=2&a=1":             "a=1&b=2",
+		"tag=x&tag=y&tag=x":   "tag=x&tag=y",
+		"q=hello+world&a=%2F": "a=%2F&q=hello+world",
+		"flag":                "flag=",
+		"bad=%zz&a=1":         "bad=%zz&a=1",
+	}
+
+	for in, out := range cases {
+		assert.Equal(t, out, normalizeQuery(in), in)
+	}
+}
+
+func TestHttp_normalizeQuery(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RawQuery + " " + r.Header.Get("X-Puma-Dev-Original-Query")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "normalized", backend.URL, "normalize_query: true\n")
+	linkTestProxyApp(t, h, "untouched", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://normalized.test/search?page=2&campus=1&page=2")
+	assert.Equal(t, "campus=1&page=2 page=2&campus=1&page=2", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://untouched.test/search?page=2&campus=1&page=2")
+	assert.Equal(t, "page=2&campus=1&page=2 ", rec.Body.String())
+}
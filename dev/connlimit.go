@@ -0,0 +1,120 @@
+package dev
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// connLimiter counts the connections each client IP has open so that
+// requests on connections past the limit can be turned away.
+type connLimiter struct {
+	max int
+
+	lock  sync.Mutex
+	open  map[string]int
+	conns map[net.Conn]*limitedConn
+}
+
+type limitedConn struct {
+	ip       string
+	rejected bool
+}
+
+func newConnLimiter(max int) *connLimiter {
+	return &connLimiter{
+		max:   max,
+		open:  make(map[string]int),
+		conns: make(map[net.Conn]*limitedConn),
+	}
+}
+
+// limitConns hooks serv up to the connection limiter, if one is configured.
+// The same limiter is shared by the HTTP and HTTPS servers.
+func (h *HTTPServer) limitConns(serv *http.Server) {
+	if h.connLimits == nil {
+		return
+	}
+
+	serv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, connContextKey, c)
+	}
+
+	serv.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateActive:
+			if lc := h.connLimits.track(c); lc != nil && lc.rejected {
+				h.Events.Add("connection_limited", "ip", lc.ip, "limit", h.connLimits.max)
+			}
+		case http.StateHijacked, http.StateClosed:
+			h.connLimits.forget(c)
+		}
+	}
+}
+
+// track counts c against its client IP once active, as with the PROXY
+// protocol the address isn't known on accept. Returns nil if already known.
+func (l *connLimiter) track(c net.Conn) *limitedConn {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.conns[c]; ok {
+		return nil
+	}
+
+	ip := c.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	lc := &limitedConn{ip: ip}
+
+	if l.open[ip] >= l.max {
+		lc.rejected = true
+	} else {
+		l.open[ip]++
+	}
+
+	l.conns[c] = lc
+
+	return lc
+}
+
+func (l *connLimiter) forget(c net.Conn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	lc, ok := l.conns[c]
+	if !ok {
+		return
+	}
+
+	delete(l.conns, c)
+
+	if lc.rejected {
+		return
+	}
+
+	l.open[lc.ip]--
+
+	if l.open[lc.ip] == 0 {
+		delete(l.open, lc.ip)
+	}
+}
+
+// rejected reports whether req arrived on a connection over its client's
+// limit.
+func (l *connLimiter) rejected(req *http.Request) bool {
+	c, ok := req.Context().Value(connContextKey).(net.Conn)
+	if !ok {
+		return false
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	lc, ok := l.conns[c]
+
+	return ok && lc.rejected
+}
@@ -0,0 +1,91 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clientConn opens a connection to addr that claims, through the PROXY
+// protocol, to come from ip and makes a request for myapp.test on it. The
+// connection is left open.
+func clientConn(t *testing.T, addr, ip string) (net.Conn, int) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	fmt.Fprintf(c, "PROXY TCP4 %s 127.0.0.1 40000 80\r\n", ip)
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: myapp.test\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	return c, resp.StatusCode
+}
+
+func TestHttp_maxConnsPerIP(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.MaxConnsPerIP = 2
+	h.Setup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	serv := &http.Server{Handler: h}
+	h.limitConns(serv)
+
+	go serv.Serve(newProxyProtoListener(l))
+	defer serv.Close()
+
+	addr := l.Addr().String()
+
+	first, status := clientConn(t, addr, "10.0.0.1")
+	assert.Equal(t, http.StatusOK, status)
+
+	second, status := clientConn(t, addr, "10.0.0.1")
+	defer second.Close()
+	assert.Equal(t, http.StatusOK, status)
+
+	third, status := clientConn(t, addr, "10.0.0.1")
+	defer third.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+
+	other, status := clientConn(t, addr, "10.0.0.2")
+	defer other.Close()
+	assert.Equal(t, http.StatusOK, status)
+
+	assert.Contains(t, eventsString(h.Events), `"event":"connection_limited","ip":"10.0.0.1","limit":2`)
+
+	first.Close()
+
+	assert.Eventually(t, func() bool {
+		c, status := clientConn(t, addr, "10.0.0.1")
+		c.Close()
+
+		return status == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+}
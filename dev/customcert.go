@@ -0,0 +1,63 @@
+package dev
+
+import (
+	"crypto/tls"
+	"strings"
+
+	"github.com/vektra/errors"
+)
+
+// CertFiles names the PEM encoded certificate and key files of a TLS
+// certificate.
+type CertFiles struct {
+	CertFile string
+	KeyFile  string
+}
+
+// configureCustomCerts serves CustomCerts by SNI, generating certs otherwise.
+func (h *HTTPServer) configureCustomCerts(tlsConfig *tls.Config) error {
+	if len(h.CustomCerts) == 0 {
+		return nil
+	}
+
+	certs := make(map[string]*tls.Certificate, len(h.CustomCerts))
+
+	for host, files := range h.CustomCerts {
+		cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return errors.Context(err, "loading certificate for "+host)
+		}
+
+		certs[strings.ToLower(host)] = &cert
+	}
+
+	fallback := tlsConfig.GetCertificate
+
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert := lookupCustomCert(certs, hello.ServerName); cert != nil {
+			return cert, nil
+		}
+
+		return fallback(hello)
+	}
+
+	return nil
+}
+
+// lookupCustomCert finds the certificate for name, either listed for it
+// directly or as a wildcard (*.example.test) for its parent domain.
+func lookupCustomCert(certs map[string]*tls.Certificate, name string) *tls.Certificate {
+	name = strings.ToLower(name)
+
+	if cert, ok := certs[name]; ok {
+		return cert
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := certs["*"+name[i:]]; ok {
+			return cert
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package dev
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestCertFiles(t *testing.T, dir, name string) CertFiles {
+	cert, key := makeTestCert(t, name, nil, nil)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	files := CertFiles{
+		CertFile: filepath.Join(dir, name+"-cert.pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+	}
+
+	ioutil.WriteFile(files.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+	ioutil.WriteFile(files.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	return files
+}
+
+func servedCertName(t *testing.T, tlsConfig *tls.Config, serverName string) string {
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestHttp_customCerts(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	generated, _ := makeTestCert(t, "generated", nil, nil)
+
+	tlsConfig := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{Certificate: [][]byte{generated.Raw}}, nil
+		},
+	}
+
+	h := &HTTPServer{
+		CustomCerts: map[string]CertFiles{
+			"shop.test":        writeTestCertFiles(t, dir, "shop"),
+			"*.Corp.Example":   writeTestCertFiles(t, dir, "wildcard"),
+			"app.corp.example": writeTestCertFiles(t, dir, "exact"),
+		},
+	}
+
+	assert.NoError(t, h.configureCustomCerts(tlsConfig))
+
+	assert.Equal(t, "shop", servedCertName(t, tlsConfig, "shop.test"))
+	assert.Equal(t, "shop", servedCertName(t, tlsConfig, "SHOP.test"))
+	assert.Equal(t, "wildcard", servedCertName(t, tlsConfig, "api.corp.example"))
+	assert.Equal(t, "exact", servedCertName(t, tlsConfig, "app.corp.example"))
+	assert.Equal(t, "generated", servedCertName(t, tlsConfig, "a.b.corp.example"))
+	assert.Equal(t, "generated", servedCertName(t, tlsConfig, "other.test"))
+}
+
+func TestHttp_customCerts_missingFile(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	h := &HTTPServer{
+		CustomCerts: map[string]CertFiles{
+			"shop.test": {CertFile: filepath.Join(dir, "missing.pem"), KeyFile: "missing-key.pem"},
+		},
+	}
+
+	assert.Error(t, h.configureCustomCerts(&tls.Config{}))
+}
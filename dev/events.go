@@ -14,6 +14,23 @@ type Events struct {
 }
 
 func (e *Events) Add(name string, args ...interface{}) string {
+	str := formatEvent(name, args)
+
+	e.events.Append(str)
+
+	return str
+}
+
+// TryAdd is Add for callers holding the pool's lock; it never waits for room.
+func (e *Events) TryAdd(name string, args ...interface{}) string {
+	str := formatEvent(name, args)
+
+	e.events.TryAppend(str)
+
+	return str
+}
+
+func formatEvent(name string, args []interface{}) string {
 	var buf bytes.Buffer
 
 	buf.WriteString("{")
@@ -28,13 +45,32 @@ func (e *Events) Add(name string, args ...interface{}) string {
 
 	buf.WriteString("}\n")
 
-	str := buf.String()
-
-	e.events.Append(str)
-
-	return str
+	return buf.String()
 }
 
 func (e *Events) WriteTo(w io.Writer) (int64, error) {
 	return e.events.WriteTo(w)
 }
+
+// Drain writes out the buffered events and removes them, making room for
+// new ones.
+func (e *Events) Drain(w io.Writer) (int64, error) {
+	return e.events.Drain(w)
+}
+
+// Reset removes the buffered events, returning how many there were.
+func (e *Events) Reset() int {
+	return e.events.Reset()
+}
+
+// SetOverflowPolicy configures what happens to new events once the buffer
+// is full. It should be called before any events are added.
+func (e *Events) SetOverflowPolicy(policy linebuffer.OverflowPolicy, blockTimeout time.Duration) {
+	e.events.Policy = policy
+	e.events.BlockTimeout = blockTimeout
+}
+
+// Dropped returns how many events were lost because the buffer was full.
+func (e *Events) Dropped() int64 {
+	return e.events.Dropped()
+}
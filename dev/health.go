@@ -0,0 +1,157 @@
+package dev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckTimeout is how long an app's health check may take
+	// to pass before the app is considered failed.
+	DefaultHealthCheckTimeout = 1 * time.Minute
+
+	healthCheckInterval = 250 * time.Millisecond
+
+	// healthCheckBodyLimit is how much of a failing health check's response
+	// is kept to show why it failed.
+	healthCheckBodyLimit = 4096
+)
+
+// unhealthyResponse is a health check that got a response, just not a 200.
+type unhealthyResponse struct {
+	status int
+	body   string
+}
+
+func (e *unhealthyResponse) Error() string {
+	return fmt.Sprintf("health check returned %d", e.status)
+}
+
+func (a *App) healthCheckClient() *http.Client {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   dialerTimeout,
+			KeepAlive: keepAlive,
+		}).DialContext,
+		TLSHandshakeTimeout: tlsHandshakeTimeout,
+		DisableKeepAlives:   true,
+	}
+
+	if a.Scheme == "httpu" {
+		socket := a.Address()
+
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: dialerTimeout}
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   dialerTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func (a *App) healthCheckURL() string {
+	if a.Scheme == "httpu" {
+		return "http://localhost" + a.Config.HealthCheckPath
+	}
+
+	return fmt.Sprintf("%s://%s%s", a.Scheme, a.Address(), a.Config.HealthCheckPath)
+}
+
+func (a *App) probeHealth(client *http.Client) error {
+	resp, err := client.Get(a.healthCheckURL())
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, healthCheckBodyLimit))
+		return &unhealthyResponse{status: resp.StatusCode, body: string(body)}
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return nil
+}
+
+// waitForHealthCheck polls HealthCheckPath until it returns a 200.
+func (a *App) waitForHealthCheck() error {
+	timeout := a.Config.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	a.eventAdd("waiting_on_health_check", "path", a.Config.HealthCheckPath)
+
+	client := a.healthCheckClient()
+	defer client.Transport.(*http.Transport).CloseIdleConnections()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	var (
+		lastErr  error
+		failures int
+	)
+
+	for {
+		select {
+		case <-a.t.Dying():
+			return fmt.Errorf("app died before passing health check")
+		case <-deadline.C:
+			reason := "no response"
+			if lastErr != nil {
+				reason = lastErr.Error()
+			}
+
+			a.healthCheckFailed(reason)
+
+			return fmt.Errorf("health check %s did not pass within %s: %s",
+				a.Config.HealthCheckPath, timeout, reason)
+		case <-ticker.C:
+			lastErr = a.probeHealth(client)
+			if lastErr == nil {
+				a.eventAdd("health_check_passed", "path", a.Config.HealthCheckPath)
+				return nil
+			}
+
+			unhealthy, ok := lastErr.(*unhealthyResponse)
+			if !ok {
+				continue
+			}
+
+			failures++
+
+			if a.Config.HealthCheckMaxFailures > 0 && failures >= a.Config.HealthCheckMaxFailures {
+				a.healthCheckFailed(lastErr.Error())
+
+				return fmt.Errorf("health check %s failed %d times, last response %d:\n%s",
+					a.Config.HealthCheckPath, failures, unhealthy.status, unhealthy.body)
+			}
+		}
+	}
+}
+
+func (a *App) healthCheckFailed(reason string) {
+	a.eventAdd("health_check_failed",
+		"path", a.Config.HealthCheckPath,
+		"error", reason,
+	)
+
+	fmt.Printf("! App '%s' failed health check %s: %s\n", a.Name, a.Config.HealthCheckPath, reason)
+}
@@ -0,0 +1,87 @@
+package dev
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttp_healthCheck_waitsForHealthy(t *testing.T) {
+	for _, config := range []string{
+		"health_check_path: /up\n",
+		"health_check_path: /up\nhealth_check_max_failures: 5\n",
+	} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		var probes int32
+
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/up" && atomic.AddInt32(&probes, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			w.Write([]byte("hello"))
+		}))
+		defer backend.Close()
+
+		linkTestProxyApp(t, h, "migrating", backend.URL, config)
+
+		rec := serveTestRequest(h, "GET", "http://migrating.test/")
+
+		assert.Equal(t, http.StatusOK, rec.Code, config)
+		assert.Equal(t, "hello", rec.Body.String(), config)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&probes), config)
+		assert.Contains(t, eventsString(h.Events), `"health_check_passed"`, config)
+	}
+}
+
+func TestHttp_healthCheck_failsAfterTimeout(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "broken", backend.URL, "health_check_path: /up\nhealth_check_timeout: 600ms\n")
+
+	rec := serveTestRequest(h, "GET", "http://broken.test/")
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "health check /up did not pass")
+	assert.Contains(t, eventsString(h.Events), `"event":"health_check_failed","app":"broken","path":"/up","error":"health check returned 503"`)
+}
+
+func TestHttp_healthCheck_maxFailures(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var probes int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("PendingMigrationError"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "broken", backend.URL, "health_check_path: /up\nhealth_check_max_failures: 2\n")
+
+	start := time.Now()
+	rec := serveTestRequest(h, "GET", "http://broken.test/")
+
+	assert.True(t, time.Since(start) < DefaultHealthCheckTimeout/2)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "health check /up failed 2 times, last response 503")
+	assert.Contains(t, rec.Body.String(), "PendingMigrationError")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&probes))
+	assert.Contains(t, eventsString(h.Events), `"event":"health_check_failed","app":"broken","path":"/up","error":"health check returned 503"`)
+}
@@ -2,9 +2,11 @@ package dev
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/http/httputil"
@@ -12,7 +14,10 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bmizerany/pat"
@@ -25,15 +30,190 @@ type HTTPServer struct {
 	Debug              bool
 	Events             *Events
 	IgnoredStaticPaths []string
-	Domains            []string
 
+	// Sorted by decreasing complexity. Change it with ReloadDomains once
+	// serving.
+	Domains []string
+
+	// Route by hostname alone, without the api.pco and Church Center
+	// rewrites.
+	DisablePCORouting bool
+
+	// Requests with a DebugRoutingParam go to the app it names, whatever
+	// their host. Only for development, anyone can pick any app.
+	DebugRouting bool
+
+	// Requests routed to an app named by a key go to its value instead.
+	Aliases map[string]string
+
+	// Consulted for hosts that match no app, before DefaultApp.
+	PathRoutes []PathRoute
+
+	// Serves hosts that match no app, told the host in X-Original-Host.
+	DefaultApp string
+
+	// Serve public/index.html for /, where there is one, instead of asking
+	// the app.
+	ServeRootIndex bool
+
+	// Files such as robots.txt and favicon.ico served to apps whose public
+	// directory doesn't have them.
+	GlobalStaticDir string
+
+	// Serves /.well-known/acme-challenge/ from its acme-challenge directory.
+	WellKnownDir string
+
+	// Host the admin APIs answer on, on any port and under any of Domains
+	// too. DefaultAdminHost unless set.
+	AdminHost       string
+	AdminCORSOrigin string
+
+	// Admin requests allowed a second, zero for no limit.
+	AdminRateLimit float64
+
+	RecordFile         string
+	ReplayFile         string
+	ReplayMatchHeaders []string
+
+	SlowRequestThreshold time.Duration
+
+	// One of the Unframed*, Truncated* and HTTP10* modes respectively.
+	UnframedResponseMode  string
+	TruncatedResponseMode string
+	HTTP10ResponseMode    string
+
+	// Zero disables either timeout. StreamingPaths are exempt from RequestTimeout.
+	ResponseHeaderTimeout time.Duration
+	RequestTimeout        time.Duration
+	StreamingPaths        []string
+
+	// Responses larger than this many bytes skip buffering, zero disables.
+	StreamThreshold int64
+
+	// Flush only responses of StreamingContentTypes as they arrive, sending
+	// others whole. DefaultStreamingContentTypes unless set.
+	FlushStreamingOnly    bool
+	StreamingContentTypes []string
+
+	// Answer requests whose path has .. segments with a 400.
+	BlockPathTraversal bool
+
+	// Pass gzipped request bodies on decompressed, malformed ones get a 400.
+	DecompressRequests bool
+
+	DisableSendfile     bool
+	StripRequestHeaders []string
+	DisableKeepAlives   bool
+
+	// Booted in the background by Setup, so they're running before the
+	// first request.
+	PrebootApps []string
+
+	// Show browsers a page that waits for booting apps, instead of a
+	// request that hangs.
+	BootInterstitial bool
+
+	// Identical GETs in flight at once share one response from the app.
+	CoalesceRequests bool
+
+	// GET, HEAD, PUT and DELETE requests whose connection to the app is
+	// reset are sent once more before failing. puma-dev turns it on.
+	RetryOnConnReset bool
+
+	// Used by the shared proxies in place of puma-dev's own transports,
+	// e.g. to test routing without real backends. Apps with their own
+	// connection pool or h2c still use theirs.
+	UnixTransport http.RoundTripper
+	TCPTransport  http.RoundTripper
+
+	// Idle connection limits of the upstream transports, zero keeps Go's
+	// defaults.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// Apps' response headers larger than this get a 502, zero keeps Go's
+	// default of 10MB.
+	MaxResponseHeaderBytes int64
+
+	JSONLogging         bool
+	ExpectProxyProtocol bool
+
+	// Ask TLS clients for a certificate, verifying it against these CAs.
+	ClientCertCAFile string
+
+	// Certificates to serve instead of generated ones, by host or *.domain.
+	CustomCerts map[string]CertFiles
+
+	// Zero means unlimited.
+	MaxConnsPerIP int
+
+	// Clients in DeniedCIDRs get a 403, as do clients outside AllowedCIDRs
+	// when it's set. Admin requests are only checked with FilterAdmin.
+	AllowedCIDRs []string
+	DeniedCIDRs  []string
+	FilterAdmin  bool
+
+	// Also send the RFC 7239 Forwarded header to apps.
+	UseForwardedHeader bool
+
+	// Set to the original host on requests rewritten to another app,
+	// DefaultEngineHostHeader unless set. Trusted, a value the client sent
+	// is kept, e.g. from a chained proxy.
+	EngineHostHeader      string
+	TrustEngineHostHeader bool
+
+	// Zero failures disables the circuit breaker.
+	CircuitBreakerFailures int
+	CircuitBreakerWindow   time.Duration
+	CircuitBreakerCooldown time.Duration
+
+	started       time.Time
 	mux           *pat.PatternServeMux
+	admin         http.Handler
+	adminRoutes   []adminRoute
 	unixTransport *http.Transport
 	unixProxy     *httputil.ReverseProxy
 	tcpTransport  *http.Transport
 	tcpProxy      *httputil.ReverseProxy
+
+	domainsLock sync.RWMutex
+	apiPattern  *regexp.Regexp
+	ccPattern   *regexp.Regexp
+	limiters    appLimiters
+	breakers    circuitBreakers
+	coalesce    coalescer
+	proxies     appProxies
+	usage       appUsages
+	inflight    inflightRequests
+	connLimits  *connLimiter
+	ipFilter    *ipFilter
+	recorder    *requestRecorder
+	replayer    *requestReplayer
+	logOutput   io.Writer
+	clientCAs   *x509.CertPool
 }
 
+const DefaultAdminHost = "puma-dev"
+
+const DefaultEngineHostHeader = "X-PCO-API-Engine-Host"
+
+var DefaultStreamingContentTypes = []string{"text/event-stream", "multipart/x-mixed-replace"}
+
+type contextKey int
+
+// appContextKey holds the *App a proxied request is being sent to.
+// connContextKey holds the net.Conn a request arrived on, when connections
+// are being limited per client. circuitContextKey holds the name the
+// request's circuit breaker is keyed by. backendContextKey holds the
+// *backend picked for an app with several.
+const (
+	appContextKey contextKey = iota
+	connContextKey
+	circuitContextKey
+	backendContextKey
+)
+
 const (
 	dialerTimeout         = 5 * time.Second
 	keepAlive             = 10 * time.Second
@@ -42,51 +222,198 @@ const (
 	proxyFlushInternal    = 1 * time.Second
 )
 
-func (h *HTTPServer) Setup() {
-	h.unixTransport = &http.Transport{
+// The response header limit http.Transport applies when none is set.
+const defaultMaxResponseHeaderBytes = 10 << 20
+
+func (h *HTTPServer) newUnixTransport() *http.Transport {
+	return &http.Transport{
 		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
 			socketPath, _, err := net.SplitHostPort(addr)
 			if err != nil {
 				return nil, err
 			}
 
+			if len(socketPath) > maxSocketPathLen {
+				h.Events.Add("socket_path_too_long", "path", socketPath, "length", len(socketPath), "max", maxSocketPathLen)
+
+				return nil, fmt.Errorf("socket path %s is %d bytes, over the %d this OS allows, move the app somewhere with a shorter path",
+					socketPath, len(socketPath), maxSocketPathLen)
+			}
+
 			dialer := net.Dialer{
 				Timeout:   dialerTimeout,
 				KeepAlive: keepAlive,
 			}
 			return dialer.DialContext(ctx, "unix", socketPath)
 		},
-		TLSHandshakeTimeout:   tlsHandshakeTimeout,
-		ExpectContinueTimeout: expectContinueTimeout,
-	}
-
-	h.unixProxy = &httputil.ReverseProxy{
-		Director:      func(_ *http.Request) {},
-		Transport:     h.unixTransport,
-		FlushInterval: proxyFlushInternal,
+		TLSHandshakeTimeout:    tlsHandshakeTimeout,
+		ExpectContinueTimeout:  expectContinueTimeout,
+		ResponseHeaderTimeout:  h.ResponseHeaderTimeout,
+		DisableKeepAlives:      h.DisableKeepAlives,
+		MaxIdleConns:           h.MaxIdleConns,
+		MaxIdleConnsPerHost:    h.MaxIdleConnsPerHost,
+		IdleConnTimeout:        h.IdleConnTimeout,
+		MaxResponseHeaderBytes: h.MaxResponseHeaderBytes,
 	}
+}
 
-	h.tcpTransport = &http.Transport{
+func (h *HTTPServer) newTCPTransport() *http.Transport {
+	return &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout:   dialerTimeout,
 			KeepAlive: keepAlive,
 		}).DialContext,
-		TLSHandshakeTimeout:   tlsHandshakeTimeout,
-		ExpectContinueTimeout: expectContinueTimeout,
+		TLSHandshakeTimeout:    tlsHandshakeTimeout,
+		ExpectContinueTimeout:  expectContinueTimeout,
+		ResponseHeaderTimeout:  h.ResponseHeaderTimeout,
+		DisableKeepAlives:      h.DisableKeepAlives,
+		MaxIdleConns:           h.MaxIdleConns,
+		MaxIdleConnsPerHost:    h.MaxIdleConnsPerHost,
+		IdleConnTimeout:        h.IdleConnTimeout,
+		MaxResponseHeaderBytes: h.MaxResponseHeaderBytes,
+	}
+}
+
+func (h *HTTPServer) newProxy(transport http.RoundTripper) *httputil.ReverseProxy {
+	if h.RetryOnConnReset {
+		transport = &retryTransport{h: h, next: transport}
 	}
 
-	h.tcpProxy = &httputil.ReverseProxy{
-		Director:      func(_ *http.Request) {},
-		Transport:     h.tcpTransport,
-		FlushInterval: proxyFlushInternal,
+	return &httputil.ReverseProxy{
+		Director:       func(_ *http.Request) {},
+		Transport:      transport,
+		FlushInterval:  proxyFlushInternal,
+		ModifyResponse: h.modifyResponse,
+		ErrorHandler:   h.proxyError,
 	}
+}
+
+func (h *HTTPServer) Setup() {
+	h.unixTransport = h.newUnixTransport()
+	h.tcpTransport = h.newTCPTransport()
+
+	var unix, tcp http.RoundTripper = h.unixTransport, h.tcpTransport
+
+	if h.UnixTransport != nil {
+		unix = h.UnixTransport
+	}
+
+	if h.TCPTransport != nil {
+		tcp = h.TCPTransport
+	}
+
+	h.unixProxy = h.newProxy(unix)
+	h.tcpProxy = h.newProxy(tcp)
+
+	h.ReloadDomains(h.Domains)
 
 	h.Pool.AppClosed = h.AppClosed
 
+	if h.AdminHost == "" {
+		h.AdminHost = DefaultAdminHost
+	}
+
+	if h.EngineHostHeader == "" {
+		h.EngineHostHeader = DefaultEngineHostHeader
+	}
+
+	if h.RecordFile != "" {
+		h.recorder = &requestRecorder{path: h.RecordFile}
+	}
+
+	if h.MaxConnsPerIP > 0 {
+		h.connLimits = newConnLimiter(h.MaxConnsPerIP)
+	}
+
+	h.ipFilter = newIPFilter(h.AllowedCIDRs, h.DeniedCIDRs)
+
+	if h.ReplayFile != "" {
+		replayer, err := loadReplay(h.ReplayFile, h.ReplayMatchHeaders)
+		if err != nil {
+			fmt.Printf("! Unable to load replay file '%s': %s\n", h.ReplayFile, err)
+		} else {
+			h.replayer = replayer
+		}
+	}
+
+	h.started = time.Now()
 	h.mux = pat.New()
+	h.adminRoutes = nil
+
+	h.handleAdmin("GET", "/healthz", h.healthz)
+	h.handleAdmin("GET", "/status", h.status)
+	h.handleAdmin("GET", "/status/:name", h.singleStatus)
+	h.handleAdmin("GET", "/events", h.events)
+	h.handleAdmin("DELETE", "/events", h.resetEvents)
+	h.handleAdmin("POST", "/apps/:name/restart", h.restartApp)
+	h.handleAdmin("POST", "/stop/:name", h.stopApp)
+	h.handleAdmin("POST", "/touch/:name", h.touchApp)
+	h.handleAdmin("GET", "/log/:name", h.appLog)
+	h.handleAdmin("GET", "/route", h.routeInfo)
+	h.handleAdmin("GET", "/inflight", h.inflightRequests)
 
-	h.mux.Get("/status", http.HandlerFunc(h.status))
-	h.mux.Get("/events", http.HandlerFunc(h.events))
+	for _, route := range h.adminRoutes {
+		h.mux.Options(route.pattern, h.preflight(route.methods))
+	}
+
+	h.admin = h.mux
+	if h.AdminRateLimit > 0 {
+		h.admin = h.rateLimitAdmin(h.mux)
+	}
+
+	for _, name := range h.PrebootApps {
+		go h.preboot(name)
+	}
+}
+
+type adminRoute struct {
+	pattern string
+	methods []string
+}
+
+// handleAdmin registers an admin endpoint and records its method so that
+// OPTIONS requests for the pattern can be answered automatically.
+func (h *HTTPServer) handleAdmin(method, pattern string, f http.HandlerFunc) {
+	methods := []string{method}
+
+	if method == "GET" {
+		h.mux.Get(pattern, f)
+		methods = append(methods, "HEAD")
+	} else {
+		h.mux.Add(method, pattern, f)
+	}
+
+	for i, route := range h.adminRoutes {
+		if route.pattern == pattern {
+			h.adminRoutes[i].methods = append(route.methods, methods...)
+			return
+		}
+	}
+
+	h.adminRoutes = append(h.adminRoutes, adminRoute{pattern, methods})
+}
+
+func (h *HTTPServer) preflight(methods []string) http.HandlerFunc {
+	allowed := make([]string, 0, len(methods)+1)
+	allowed = append(allowed, methods...)
+	allowed = append(allowed, "OPTIONS")
+	allow := strings.Join(allowed, ", ")
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Allow", allow)
+
+		if h.AdminCORSOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+
+			if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
 }
 
 func (h *HTTPServer) AppClosed(app *App) {
@@ -95,6 +422,43 @@ func (h *HTTPServer) AppClosed(app *App) {
 	// but that's ok.
 	h.unixTransport.CloseIdleConnections()
 	h.tcpTransport.CloseIdleConnections()
+	h.proxies.remove(app)
+}
+
+// ReloadDomains swaps the domains apps are served on, e.g. on SIGHUP,
+// without dropping connections.
+func (h *HTTPServer) ReloadDomains(domains []string) {
+	domains = append([]string(nil), domains...)
+
+	sort.SliceStable(domains, func(i, j int) bool {
+		return strings.Count(domains[i], ".") > strings.Count(domains[j], ".")
+	})
+
+	tlds := tldPattern(domains)
+	apiPattern := regexp.MustCompile(`^api\.(pco|churchcenter)\.` + tlds + `$`)
+	ccPattern := regexp.MustCompile(`^([\w-]+)\.churchcenter\.` + tlds + `$`)
+
+	h.domainsLock.Lock()
+	defer h.domainsLock.Unlock()
+
+	h.Domains = domains
+	h.apiPattern = apiPattern
+	h.ccPattern = ccPattern
+}
+
+// tldPattern matches any of domains, or any TLD when there are none, like
+// removeTLD does.
+func tldPattern(domains []string) string {
+	if len(domains) == 0 {
+		return `([^.]+)`
+	}
+
+	quoted := make([]string, len(domains))
+	for i, domain := range domains {
+		quoted[i] = regexp.QuoteMeta(domain)
+	}
+
+	return "(" + strings.Join(quoted, "|") + ")"
 }
 
 func (h *HTTPServer) removeTLD(host string) string {
@@ -133,84 +497,112 @@ func (h *HTTPServer) removeTLD(host string) string {
 }
 
 func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var logEntry *requestLog
+
+	if h.JSONLogging {
+		w, logEntry = h.startRequestLog(w, req)
+		defer h.finishRequestLog(logEntry)
+	}
+
 	if h.Debug {
-		fmt.Fprintf(os.Stderr, "%s: %s '%s' (host=%s)\n",
+		fmt.Fprintf(h.logWriter(), "%s: %s '%s' (host=%s)\n",
 			time.Now().Format(time.RFC3339Nano),
 			req.Method, req.URL.Path, req.Host)
 	}
 
-	if req.Host == "puma-dev" {
-		h.mux.ServeHTTP(w, req)
+	if h.connLimits != nil && h.connLimits.rejected(req) {
+		w.Header().Set("Connection", "close")
+		http.Error(w, "too many connections from your address", http.StatusServiceUnavailable)
 		return
 	}
 
-	name := h.removeTLD(req.Host)
-
-	host := strings.Split(req.Host, ":")[0]
-
-	// Check for API requests.
-	apiPattern := regexp.MustCompile(`^api\.(pco|churchcenter)\.(test|codes)$`)
-	apiMatch := apiPattern.FindStringSubmatch(host)
-	if apiMatch != nil {
-		// Both api.pco.test and api.churchcenter.test go to the API app by default,
-		// but we need to check the path to be sure.
-		v2Pattern := regexp.MustCompile(`^/([\w-]+)/v2`)
-		v2Match := v2Pattern.FindStringSubmatch(req.URL.Path)
-		if v2Match != nil && v2Match[1] != "global" {
-			// The path indicates a different app, e.g. /services/v2/
-			// ...so we'll proxy to that app instead.
-			name = fmt.Sprintf("%s.pco", v2Match[1])
-			// We have to change the host header to match the app to which we're sending the request.
-			req.Header.Set("Host", fmt.Sprintf("%s.pco.test", v2Match[1]))
-			req.Header.Set("X-PCO-API-Engine-Host", host)
-		} else {
-			// This is a plain request to the API app.
-			name = "api.pco"
+	admin := h.isAdminHost(req.Host)
+
+	if (h.FilterAdmin || !admin) && h.rejectClient(w, req) {
+		return
+	}
+
+	if admin {
+		if h.AdminCORSOrigin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", h.AdminCORSOrigin)
+			w.Header().Add("Vary", "Origin")
 		}
+
+		h.admin.ServeHTTP(w, req)
+		return
 	}
 
-	// Check for Church Center requests.
-	ccAppPattern := regexp.MustCompile(`^\/(giving|groups|people|publishing|registrations)`)
-	ccPattern := regexp.MustCompile(`^([\w-]+)\.churchcenter\.(test|codes)$`)
-	ccSubdomainMatch := ccPattern.FindStringSubmatch(host)
-	if ccSubdomainMatch != nil && ccSubdomainMatch[1] != "api" {
-		ccPathMatch := ccAppPattern.FindStringSubmatch(req.URL.Path)
-		if ccPathMatch != nil {
-			// This is a request for a specific Church Center app.
-			name = fmt.Sprintf("%s.pco", ccPathMatch[1])
-			// We have to change the host header to match the app to which we're sending the request.
-			req.Header.Set("Host", fmt.Sprintf("%s.pco.test", ccPathMatch[1]))
-			// The path needs to be rewritten to include the subdomain and directory
-			// so the app knows from whence this request actually came.
-			req.URL.Path = ccAppPattern.ReplaceAllString(req.URL.Path, "/church_center")
-			// This matches `?foo=bar...` and captures the `foo=bar` part.
-			paramsPattern := regexp.MustCompile(`\?(.*)$|$`)
-			req.URL.Path = paramsPattern.ReplaceAllString(req.URL.Path,
-				fmt.Sprintf("?church_center_directory=%s&church_center_subdomain=%s&$1", ccPathMatch[1], ccSubdomainMatch[1]),
-			)
-		} else {
-			// This is a plain request to the Church Center app itself.
-			name = "churchcenter"
+	for _, name := range h.StripRequestHeaders {
+		req.Header.Del(name)
+	}
+
+	if h.BlockPathTraversal && hasPathTraversal(req.URL.Path) {
+		h.Events.Add("path_traversal_blocked", "host", req.Host, "path", req.URL.EscapedPath())
+
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	if h.DecompressRequests {
+		if err := decompressRequest(req); err != nil {
+			h.Events.Add("request_decompress_error", "host", req.Host, "path", req.URL.Path, "error", err.Error())
+
+			http.Error(w, "malformed gzip request body", http.StatusBadRequest)
+			return
 		}
 	}
 
-	// Check to see if the path starts with ~api or ~ccapi.
-	squigglyPattern := regexp.MustCompile(`^\/~(api|ccapi)\/([\w-]+)`)
-	squigglyMatch := squigglyPattern.FindStringSubmatch(req.URL.Path)
-	if squigglyMatch != nil {
-		// Ahhh, this is a same-domain request in disguise! We need to proxy this
-		// to a different app than the hostname indicates.
-		name = fmt.Sprintf("%s.pco", squigglyMatch[2])
-		req.Header.Set("Host", fmt.Sprintf("%s.pco.test", squigglyMatch[2]))
-		req.Header.Set("X-PCO-API-Engine-Host", host)
+	if h.serveACMEChallenge(w, req) || h.serveBootStatus(w, req) {
+		return
+	}
+
+	rt, forced := h.debugRoute(req)
+	if !forced {
+		rt = h.route(req.Host, req.URL.Path)
+	}
+
+	name := rt.App
+
+	if rt.Host != "" {
+		req.Header.Set("Host", rt.Host)
+	}
+
+	if rt.EngineHost != "" {
+		h.setEngineHost(req, rt.EngineHost)
+	}
+
+	req.URL.Path = rt.Path
+
+	if left := h.breakers.openFor(name); left > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(left.Seconds()))))
+		http.Error(w, fmt.Sprintf("app '%s' keeps failing, try again in %s", name, left.Round(time.Second)),
+			http.StatusServiceUnavailable)
+		return
+	}
+
+	app, subdomain, err := h.Pool.FindAppWithSubdomain(name)
+	if err == ErrUnknownApp {
+		if pathApp, ok := h.routeByPath(req); ok {
+			name = pathApp
+			app, subdomain, err = h.Pool.FindAppWithSubdomain(name)
+		}
+	}
+
+	if err == ErrUnknownApp && h.DefaultApp != "" && name != h.DefaultApp {
+		h.Events.Add("unknown_app", "name", name, "host", req.Host, "default_app", h.DefaultApp)
+
+		req.Header.Set("X-Original-Host", req.Host)
+		name = h.DefaultApp
+		app, subdomain, err = h.Pool.FindAppWithSubdomain(name)
 	}
 
-	app, err := h.Pool.FindAppByDomainName(name)
 	if err != nil {
 		if err == ErrUnknownApp {
 			h.Events.Add("unknown_app", "name", name, "host", req.Host)
 		} else {
-			h.Events.Add("lookup_error", "error", err.Error())
+			h.Events.Add("lookup_error",
+				"name", name, "host", req.Host, "method", req.Method, "path", req.URL.Path,
+				"error", err.Error())
 		}
 
 		w.WriteHeader(500)
@@ -218,55 +610,296 @@ func (h *HTTPServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = app.WaitTilReady()
+	if logEntry != nil {
+		logEntry.ResolvedApp = app.Name
+		logEntry.Mode = "local"
+
+		if app.Config.upstream != nil {
+			logEntry.Mode = "upstream"
+		}
+	}
+
+	if !app.Config.allowsHost(req.Host) {
+		h.Events.Add("host_rejected", "app", app.Name, "host", req.Host)
+
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(fmt.Sprintf("host '%s' is not allowed to reach app '%s'", req.Host, app.Name)))
+		return
+	}
+
+	if h.replayer != nil && h.replayer.serve(w, req) {
+		h.Events.Add("request_replayed", "method", req.Method, "host", req.Host, "path", req.URL.Path)
+		return
+	}
+
+	if h.serveBootInterstitial(w, req, app) {
+		return
+	}
+
+	if h.recorder != nil {
+		var save func() error
+
+		w, save = h.recorder.record(w, req)
+
+		defer func() {
+			if err := save(); err != nil {
+				h.Events.Add("record_error", "error", err.Error())
+			}
+		}()
+	}
+
+	h.usage.record(app.Name)
+
+	// Deferred, so the request is forgotten even if the proxy panics.
+	defer h.inflight.track(req, app.Name)()
+
+	if app.Config.upstream == nil {
+		err = app.WaitTilReady()
+	}
+
 	if err != nil {
+		h.circuitFailure(name)
+
+		if serveMaintenancePage(w, app, err) {
+			return
+		}
+
 		w.WriteHeader(500)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
+	// Done after the app is picked, so the path based routing above still
+	// sees the full path.
+	if stripped, ok := app.Config.stripPathPrefix(req.URL.Path); ok {
+		req.Header.Set("X-Forwarded-Prefix", app.Config.StripPrefix)
+		req.URL.Path = stripped
+		req.URL.RawPath = ""
+	}
+
+	if app.Config.NormalizeQuery && req.URL.RawQuery != "" {
+		req.Header.Set("X-Puma-Dev-Original-Query", req.URL.RawQuery)
+		req.URL.RawQuery = normalizeQuery(req.URL.RawQuery)
+	}
+
 	if h.shouldServePublicPathForApp(app, req) {
-		safeURLPath := path.Clean(req.URL.Path)
-		path := filepath.Join(app.dir, "public", safeURLPath)
+		file := req.URL.Path
+		if path.Clean(file) == "/" {
+			file = "/index.html"
+		}
+
+		path := publicPath(app, file)
 
 		fi, err := os.Stat(path)
 		if err == nil && !fi.IsDir() {
-			if ofile, err := os.Open(path); err == nil {
-				http.ServeContent(w, req, req.URL.Path, fi.ModTime(), io.ReadSeeker(ofile))
+			if h.serveStaticFile(w, req, path, fi) {
 				return
 			}
 		}
 	}
 
-	if req.TLS == nil {
-		req.Header.Set("X-Forwarded-Proto", "http")
+	if h.serveGlobalStatic(w, req, app) {
+		return
+	}
+
+	if limiter := h.limiters.limiterFor(app); limiter != nil {
+		err = limiter.Acquire(req.Context())
+		if err != nil {
+			h.Events.Add("request_rejected", "app", app.Name, "error", err.Error())
+
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		start := time.Now()
+
+		defer func() {
+			limiter.Release(time.Since(start))
+		}()
+	}
+
+	if subdomain != "" {
+		req.Header.Set("X-Forwarded-Subdomain", subdomain)
 	} else {
-		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Del("X-Forwarded-Subdomain")
+	}
+
+	h.setClientCertHeaders(req)
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	req.Header.Set("X-Forwarded-Proto", proto)
+
+	if h.UseForwardedHeader {
+		req.Header.Set("Forwarded", forwardedHeader(req, proto))
+	}
+
+	if h.SlowRequestThreshold > 0 {
+		start := time.Now()
+
+		defer func() {
+			if elapsed := time.Since(start); elapsed > h.SlowRequestThreshold {
+				h.Events.Add("slow_request",
+					"app", app.Name,
+					"method", req.Method,
+					"path", req.URL.Path,
+					"duration", elapsed.String(),
+				)
+			}
+		}()
+	}
+
+	prepareWebSocketUpgrade(app, req)
+
+	req, cancel := h.withRequestTimeout(req)
+	defer cancel()
+
+	ctx := context.WithValue(req.Context(), appContextKey, app)
+	req = req.WithContext(context.WithValue(ctx, circuitContextKey, name))
+
+	if len(app.Config.PreserveHeaderCase) > 0 {
+		recaseHeaders(req.Header, app.Config.PreserveHeaderCase)
+		w = &headerCaseWriter{ResponseWriter: w, names: app.Config.PreserveHeaderCase}
+	}
+
+	if h.StreamThreshold > 0 {
+		w = &streamingWriter{ResponseWriter: w, threshold: h.StreamThreshold}
+	}
+
+	if h.FlushStreamingOnly {
+		types := h.StreamingContentTypes
+		if len(types) == 0 {
+			types = DefaultStreamingContentTypes
+		}
+
+		w = &flushPolicyWriter{ResponseWriter: w, types: types}
+	}
+
+	if app.Config.UpstreamHost != "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+		req.Host = app.Config.UpstreamHost
+	}
+
+	if upstream := app.Config.bodySizeUpstream(req.ContentLength); upstream != nil {
+		req.URL.Scheme, req.URL.Host = upstream.Scheme, upstream.Host
+		h.debugProxy(req, app, upstream.Scheme, upstream.Host)
+		h.tcpProxy.ServeHTTP(w, req)
+		return
+	}
+
+	if upstream := app.Config.upstream; upstream != nil {
+		req.URL.Scheme, req.URL.Host = upstream.Scheme, upstream.Host
+		if app.Config.UpstreamHost == "" {
+			req.Host = upstream.Host
+		}
+		h.debugProxy(req, app, upstream.Scheme, upstream.Host)
+		h.tcpProxy.ServeHTTP(w, req)
+		return
+	}
+
+	proxy := h.proxies.proxyFor(h, app)
+
+	address := app.Address()
+	if b := app.balancer.pick(app.Config.Balance); b != nil {
+		defer app.balancer.done(b)
+
+		address = b.address()
+		req = req.WithContext(context.WithValue(req.Context(), backendContextKey, b))
 	}
 
-	req.URL.Scheme, req.URL.Host = app.Scheme, app.Address()
 	if app.Scheme == "httpu" {
-		req.URL.Scheme, req.URL.Host = "http", app.Address()
-		h.unixProxy.ServeHTTP(w, req)
+		req.URL.Scheme, req.URL.Host = "http", address
+		if proxy == nil {
+			proxy = h.unixProxy
+		}
 	} else {
-		req.URL.Scheme, req.URL.Host = app.Scheme, app.Address()
-		h.tcpProxy.ServeHTTP(w, req)
+		req.URL.Scheme, req.URL.Host = app.Scheme, address
+		if proxy == nil {
+			proxy = h.tcpProxy
+		}
+	}
+
+	h.debugProxy(req, app, app.Scheme, address)
+
+	if h.CoalesceRequests {
+		h.coalesce.serve(h, w, req, proxy)
+		return
+	}
+
+	proxy.ServeHTTP(w, req)
+}
+
+// debugProxy shows where req is being sent, as the PCO rewrites can pick a
+// surprising app, and for apps with log_headers its headers.
+func (h *HTTPServer) debugProxy(req *http.Request, app *App, scheme, address string) {
+	h.logRequestHeaders(req, app)
+
+	if !h.Debug {
+		return
+	}
+
+	fmt.Fprintf(h.logWriter(), "%s: %s '%s' -> app=%s %s://%s\n",
+		time.Now().Format(time.RFC3339Nano),
+		req.Method, req.URL.Path, app.Name, scheme, address)
+}
+
+// forwardedHeader describes req as a Forwarded header value.
+func forwardedHeader(req *http.Request, proto string) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	return fmt.Sprintf("for=%s;host=%s;proto=%s",
+		forwardedValue(ip, strings.Contains(ip, ":")), forwardedValue(req.Host, false), proto)
+}
+
+// forwardedValue quotes v where RFC 7239 requires it, bracketing IPv6 addresses.
+func forwardedValue(v string, ipv6 bool) string {
+	if ipv6 {
+		return `"[` + v + `]"`
+	}
+
+	if strings.ContainsAny(v, ":[]") {
+		return `"` + v + `"`
+	}
+
+	return v
+}
+
+func (h *HTTPServer) setEngineHost(req *http.Request, host string) {
+	if h.TrustEngineHostHeader && req.Header.Get(h.EngineHostHeader) != "" {
+		return
 	}
+
+	req.Header.Set(h.EngineHostHeader, host)
 }
 
 func (h *HTTPServer) shouldServePublicPathForApp(a *App, req *http.Request) bool {
+	return a.Public && h.shouldServeStaticPath(req)
+}
+
+// shouldServeStaticPath reports whether req may be answered with a file
+// rather than by the app.
+func (h *HTTPServer) shouldServeStaticPath(req *http.Request) bool {
 	reqPath := path.Clean(req.URL.Path)
 
-	if !a.Public {
+	// Anything that isn't a plain read, e.g. a form posted to a path that
+	// also exists as a file, is for the app to handle.
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
 		return false
 	}
 
-	if reqPath == "/" {
+	if reqPath == "/" && !h.ServeRootIndex {
 		return false
 	}
 
 	for _, ignoredPath := range h.IgnoredStaticPaths {
-		if strings.HasPrefix(reqPath, ignoredPath) {
+		if ignoresStaticPath(ignoredPath, reqPath) {
 			if h.Debug {
 				fmt.Fprintf(os.Stdout, "Not serving '%s' as it matches a path in no-serve-public-paths\n", reqPath)
 			}
@@ -277,41 +910,353 @@ func (h *HTTPServer) shouldServePublicPathForApp(a *App, req *http.Request) bool
 	return true
 }
 
-func (h *HTTPServer) status(w http.ResponseWriter, req *http.Request) {
-	type appStatus struct {
-		Scheme  string `json:"scheme"`
-		Address string `json:"address"`
-		Status  string `json:"status"`
-		Log     string `json:"log"`
+// Entries with a * are matched with path.Match against reqPath and its
+// parents, others are plain prefixes.
+func ignoresStaticPath(ignoredPath, reqPath string) bool {
+	if !strings.Contains(ignoredPath, "*") {
+		return strings.HasPrefix(reqPath, ignoredPath)
+	}
+
+	for p := reqPath; p != "/" && p != "."; p = path.Dir(p) {
+		if ok, _ := path.Match(ignoredPath, p); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+type appStatus struct {
+	Scheme           string            `json:"scheme"`
+	Address          string            `json:"address"`
+	Status           string            `json:"status"`
+	Log              string            `json:"log"`
+	ConcurrencyLimit int               `json:"concurrency_limit,omitempty"`
+	RequestCount     int64             `json:"request_count"`
+	LastAccessed     string            `json:"last_accessed,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	PID              int               `json:"pid"`
+	MemoryBytes      int64             `json:"memory_bytes"`
+	Dir              string            `json:"dir,omitempty"`
+	Command          []string          `json:"command,omitempty"`
+}
+
+// appMemory reads the resident memory of an app's process. It is a
+// variable so tests can check it runs outside the pool's lock.
+var appMemory = processMemory
+
+func (h *HTTPServer) appStatus(a *App) appStatus {
+	usage := h.usage.get(a.Name)
+
+	status := appStatus{
+		Scheme:           a.Scheme,
+		Address:          a.Address(),
+		Status:           statusName(a.Status()),
+		Log:              a.Log(),
+		ConcurrencyLimit: h.limiters.currentLimit(a.Name),
+		RequestCount:     usage.requests,
+		Labels:           a.Config.MetricsLabels,
+		PID:              a.pid(),
+		Dir:              a.dir,
+	}
+
+	if cmd := a.command(); cmd != nil {
+		status.Command = cmd.Args
 	}
 
+	if !usage.lastAccessed.IsZero() {
+		status.LastAccessed = usage.lastAccessed.Format(time.RFC3339)
+	}
+
+	if status.PID != 0 {
+		status.MemoryBytes = appMemory(status.PID)
+	}
+
+	return status
+}
+
+func (h *HTTPServer) status(w http.ResponseWriter, req *http.Request) {
 	statuses := map[string]appStatus{}
 
+	var apps []*App
+
+	// Reading memory can mean running ps, which mustn't hold up requests
+	// waiting on the pool's lock.
 	h.Pool.ForApps(func(a *App) {
-		var status string
-
-		switch a.Status() {
-		case Dead:
-			status = "dead"
-		case Booting:
-			status = "booting"
-		case Running:
-			status = "running"
-		default:
-			status = "unknown"
-		}
+		apps = append(apps, a)
+	})
+
+	for _, a := range apps {
+		statuses[a.Name] = h.appStatus(a)
+	}
 
-		statuses[a.Name] = appStatus{
-			Scheme:  a.Scheme,
-			Address: a.Address(),
-			Status:  status,
-			Log:     a.Log(),
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// singleStatus reports the status of one running app.
+func (h *HTTPServer) singleStatus(w http.ResponseWriter, req *http.Request) {
+	app, err := h.Pool.FindRunningApp(req.URL.Query().Get(":name"))
+	if err != nil {
+		if err == ErrUnknownApp || err == ErrAppNotRunning {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
 		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	json.NewEncoder(w).Encode(h.appStatus(app))
+}
+
+// healthz reports that the proxy itself is up, without waiting on any app.
+func (h *HTTPServer) healthz(w http.ResponseWriter, req *http.Request) {
+	var apps int
+
+	h.Pool.ForApps(func(_ *App) {
+		apps++
 	})
 
-	json.NewEncoder(w).Encode(statuses)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Uptime string `json:"uptime"`
+		Apps   int    `json:"apps"`
+	}{
+		Uptime: time.Since(h.started).Round(time.Second).String(),
+		Apps:   apps,
+	})
+}
+
+func statusName(status int) string {
+	switch status {
+	case Dead:
+		return "dead"
+	case Booting:
+		return "booting"
+	case Running:
+		return "running"
+	default:
+		return "unknown"
+	}
 }
 
 func (h *HTTPServer) events(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("X-Puma-Dev-Events-Dropped", strconv.FormatInt(h.Events.Dropped(), 10))
+
+	if req.URL.Query().Get("drain") == "true" {
+		h.Events.Drain(w)
+		return
+	}
+
 	h.Events.WriteTo(w)
 }
+
+// resetEvents clears the events, for starting a fresh debugging session.
+func (h *HTTPServer) resetEvents(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Cleared int `json:"cleared"`
+	}{h.Events.Reset()})
+}
+
+func (h *HTTPServer) restartApp(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get(":name")
+
+	app, err := h.Pool.FindAppByDomainName(name)
+	if err != nil {
+		if err == ErrUnknownApp {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = app.Restart("restart requested via api")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":   app.Name,
+		"status": "restarting",
+	})
+}
+
+// How long stopApp waits for the app to exit before reporting its status.
+const stopWait = 5 * time.Second
+
+// stopApp shuts a running app down to free its memory, it boots again on
+// its next request. An app that isn't running is reported as dead.
+func (h *HTTPServer) stopApp(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get(":name")
+
+	app, err := h.Pool.FindRunningApp(name)
+	if err == ErrAppNotRunning {
+		json.NewEncoder(w).Encode(map[string]string{
+			"name":   name,
+			"status": statusName(Dead),
+		})
+		return
+	}
+
+	if err != nil {
+		if err == ErrUnknownApp {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Restart only stops the app, leaving the next request to boot it.
+	err = app.Restart("stop requested via api")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	select {
+	case <-app.t.Dead():
+	case <-time.After(stopWait):
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":   app.Name,
+		"status": statusName(app.Status()),
+	})
+}
+
+// How long touchApp waits for the app to restart before reporting its status.
+const touchRestartWait = 5 * time.Second
+
+// touchApp touches tmp/restart.txt and reports the restarted app's status.
+func (h *HTTPServer) touchApp(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get(":name")
+
+	app, err := h.Pool.FindRunningApp(name)
+	if err != nil {
+		if err == ErrUnknownApp || err == ErrAppNotRunning {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if app.dir == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("proxy apps have no tmp/restart.txt"))
+		return
+	}
+
+	restart := filepath.Join(app.dir, "tmp", "restart.txt")
+
+	now := time.Now()
+
+	err = os.Chtimes(restart, now, now)
+	if os.IsNotExist(err) {
+		var f *os.File
+
+		f, err = os.Create(restart)
+		if err == nil {
+			f.Close()
+		}
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	app.eventAdd("restart_touched", "path", restart)
+
+	deadline := time.After(touchRestartWait)
+
+	select {
+	case <-app.t.Dying():
+		app.t.Wait()
+
+		app, err = h.Pool.FindAppByDomainName(name)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		select {
+		case <-app.readyChan:
+		case <-app.t.Dying():
+		case <-deadline:
+		}
+	case <-deadline:
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":   app.Name,
+		"status": statusName(app.Status()),
+	})
+}
+
+// appLog sends a running app's log file, or the lines kept in memory.
+func (h *HTTPServer) appLog(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get(":name")
+
+	tail := -1
+
+	if v := req.URL.Query().Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("tail must be a non-negative number"))
+			return
+		}
+
+		tail = n
+	}
+
+	app, err := h.Pool.FindRunningApp(name)
+	if err != nil {
+		if err == ErrUnknownApp || err == ErrAppNotRunning {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if app.logFile == nil {
+		// Only the last linebuffer.DefaultSize lines are kept in memory.
+		w.Header().Set("X-Puma-Dev-Log-Source", "buffer")
+
+		if app.lines.Dropped() > 0 {
+			w.Header().Set("X-Puma-Dev-Log-Truncated", "true")
+		}
+
+		writeLogLines(w, strings.NewReader(app.Log()), tail)
+		return
+	}
+
+	w.Header().Set("X-Puma-Dev-Log-Source", "file")
+
+	r := app.logFile.reader()
+	defer r.Close()
+
+	writeLogLines(w, r, tail)
+}
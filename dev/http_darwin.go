@@ -2,6 +2,7 @@ package dev
 
 import (
 	"crypto/tls"
+	"net"
 	"net/http"
 
 	"github.com/puma/puma-dev/dev/launch"
@@ -9,6 +10,10 @@ import (
 	"gopkg.in/tomb.v2"
 )
 
+// maxSocketPathLen is the longest unix socket path that fits in sun_path,
+// leaving room for the terminating NUL.
+const maxSocketPathLen = 103
+
 func (h *HTTPServer) ServeTLS(launchdSocket string) error {
 	certCache := NewCertCache()
 
@@ -16,14 +21,35 @@ func (h *HTTPServer) ServeTLS(launchdSocket string) error {
 		GetCertificate: certCache.GetCertificate,
 	}
 
+	err := h.configureClientCerts(tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	err = h.configureCustomCerts(tlsConfig)
+	if err != nil {
+		return err
+	}
+
 	serv := http.Server{
 		Addr:      h.TLSAddress,
 		Handler:   h,
 		TLSConfig: tlsConfig,
 	}
 
+	h.limitConns(&serv)
+
 	if launchdSocket == "" {
-		return serv.ListenAndServeTLS("", "")
+		if !h.ExpectProxyProtocol {
+			return serv.ListenAndServeTLS("", "")
+		}
+
+		l, err := net.Listen("tcp", serv.Addr)
+		if err != nil {
+			return err
+		}
+
+		return serv.ServeTLS(newProxyProtoListener(l), "", "")
 	}
 
 	listeners, err := launch.SocketListeners(launchdSocket)
@@ -34,6 +60,10 @@ func (h *HTTPServer) ServeTLS(launchdSocket string) error {
 	var t tomb.Tomb
 
 	for i, l := range listeners {
+		if h.ExpectProxyProtocol {
+			l = newProxyProtoListener(l)
+		}
+
 		tl := tls.NewListener(l, tlsConfig)
 		listeners[i] = tl
 	}
@@ -54,8 +84,19 @@ func (h *HTTPServer) Serve(launchdSocket string) error {
 		Handler: h,
 	}
 
+	h.limitConns(&serv)
+
 	if launchdSocket == "" {
-		return serv.ListenAndServe()
+		if !h.ExpectProxyProtocol {
+			return serv.ListenAndServe()
+		}
+
+		l, err := net.Listen("tcp", serv.Addr)
+		if err != nil {
+			return err
+		}
+
+		return serv.Serve(newProxyProtoListener(l))
 	}
 
 	listeners, err := launch.SocketListeners(launchdSocket)
@@ -66,6 +107,10 @@ func (h *HTTPServer) Serve(launchdSocket string) error {
 	var t tomb.Tomb
 
 	for _, l := range listeners {
+		if h.ExpectProxyProtocol {
+			l = newProxyProtoListener(l)
+		}
+
 		t.Go(func() error {
 			return serv.Serve(l)
 		})
@@ -2,9 +2,13 @@ package dev
 
 import (
 	"crypto/tls"
+	"net"
 	"net/http"
 )
 
+// maxSocketPathLen is the longest unix socket path that fits in sun_path.
+const maxSocketPathLen = 108
+
 func (h *HTTPServer) ServeTLS() error {
 	certCache := NewCertCache()
 
@@ -12,13 +16,34 @@ func (h *HTTPServer) ServeTLS() error {
 		GetCertificate: certCache.GetCertificate,
 	}
 
+	err := h.configureClientCerts(tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	err = h.configureCustomCerts(tlsConfig)
+	if err != nil {
+		return err
+	}
+
 	serv := http.Server{
 		Addr:      h.TLSAddress,
 		Handler:   h,
 		TLSConfig: tlsConfig,
 	}
 
-	return serv.ListenAndServeTLS("", "")
+	h.limitConns(&serv)
+
+	if !h.ExpectProxyProtocol {
+		return serv.ListenAndServeTLS("", "")
+	}
+
+	l, err := net.Listen("tcp", serv.Addr)
+	if err != nil {
+		return err
+	}
+
+	return serv.ServeTLS(newProxyProtoListener(l), "", "")
 }
 
 func (h *HTTPServer) Serve() error {
@@ -27,5 +52,16 @@ func (h *HTTPServer) Serve() error {
 		Handler: h,
 	}
 
-	return serv.ListenAndServe()
+	h.limitConns(&serv)
+
+	if !h.ExpectProxyProtocol {
+		return serv.ListenAndServe()
+	}
+
+	l, err := net.Listen("tcp", serv.Addr)
+	if err != nil {
+		return err
+	}
+
+	return serv.Serve(newProxyProtoListener(l))
 }
@@ -1,8 +1,21 @@
 package dev
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/puma/puma-dev/linebuffer"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -63,3 +76,972 @@ func TestHttp_removeTLD_multipartTLDSimilarToShorterOne(t *testing.T) {
 
 	assert.Equal(t, "confusing-riddle", str)
 }
+
+func newTestHTTPServer(t testing.TB) (*HTTPServer, func()) {
+	dir, err := ioutil.TempDir("", "puma-dev-test")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	var events Events
+
+	h := &HTTPServer{
+		Pool:    &AppPool{Dir: dir, IdleTime: time.Minute, Events: &events},
+		Events:  &events,
+		Domains: []string{"test"},
+	}
+
+	h.Setup()
+
+	return h, func() {
+		h.Pool.Purge()
+		os.RemoveAll(dir)
+	}
+}
+
+// testTempDir makes an empty directory for a test. The returned func
+// removes it again.
+func testTempDir(t testing.TB) (string, func()) {
+	dir, err := ioutil.TempDir("", "puma-dev-test")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// linkTestProxyApp links a proxy app named name to the backend at url,
+// writing config as the app's config file when it is not empty.
+func linkTestProxyApp(t *testing.T, h *HTTPServer, name, url, config string) {
+	path := filepath.Join(h.Pool.Dir, name)
+
+	if err := ioutil.WriteFile(path, []byte(url), 0644); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	if config == "" {
+		return
+	}
+
+	if err := ioutil.WriteFile(appConfigPath(path, false), []byte(config), 0644); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+}
+
+func serveTestRequest(h *HTTPServer, method, url string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, nil)
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHttp_adminPreflight(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.AdminCORSOrigin = "http://dashboard.example"
+
+	req := httptest.NewRequest("OPTIONS", "http://puma-dev/apps/myapp/restart", nil)
+	req.Header.Set("Origin", "http://dashboard.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "POST, OPTIONS", rec.Header().Get("Allow"))
+	assert.Equal(t, "POST, OPTIONS", rec.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "http://dashboard.example", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Content-Type", rec.Header().Get("Access-Control-Allow-Headers"))
+}
+
+func TestHttp_adminPreflight_getRoute(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	rec := serveTestRequest(h, "OPTIONS", "http://puma-dev/status")
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "GET, HEAD, OPTIONS", rec.Header().Get("Allow"))
+}
+
+func TestHttp_adminPreflight_noCORSByDefault(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	rec := serveTestRequest(h, "OPTIONS", "http://puma-dev/apps/myapp/restart")
+
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "POST, OPTIONS", rec.Header().Get("Allow"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestHttp_adminHost(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.AdminHost = "admin.localhost"
+	h.Setup()
+
+	rec := serveTestRequest(h, "GET", "http://admin.localhost/status")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, json.Valid(rec.Body.Bytes()))
+
+	rec = serveTestRequest(h, "GET", "http://puma-dev/status")
+	assert.NotEqual(t, http.StatusOK, rec.Code)
+}
+
+func TestHttp_adminHost_portAndDomain(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.ReloadDomains([]string{"test", "localhost"})
+
+	for _, host := range []string{"puma-dev", "puma-dev:80", "puma-dev.test", "puma-dev.localhost:9280", "Puma-Dev.Test"} {
+		rec := serveTestRequest(h, "GET", "http://"+host+"/status")
+		assert.Equal(t, http.StatusOK, rec.Code, host)
+		assert.True(t, json.Valid(rec.Body.Bytes()), host)
+	}
+
+	for _, host := range []string{"puma-dev.example", "app.puma-dev.test"} {
+		assert.False(t, h.isAdminHost(host), host)
+	}
+}
+
+func TestHttp_restartApp_unknown(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	rec := serveTestRequest(h, "POST", "http://puma-dev/apps/doesnotexist/restart")
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestHttp_restartApp_proxy(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	rec := serveTestRequest(h, "POST", "http://puma-dev/apps/myapp/restart")
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.JSONEq(t, `{"name":"myapp","status":"restarting"}`, rec.Body.String())
+}
+
+func TestHttp_wildcardSubdomain(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-Subdomain")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	cases := map[string]string{
+		"http://myapp.test/":                  "",
+		"http://tenant1.myapp.test/":          "tenant1",
+		"http://tenant2.myapp.test/":          "tenant2",
+		"http://admin.tenant1.myapp.test/":    "admin.tenant1",
+		"http://a.b.admin.tenant1.myapp.test": "a.b.admin.tenant1",
+	}
+
+	for url, subdomain := range cases {
+		rec := serveTestRequest(h, "GET", url)
+
+		assert.Equal(t, http.StatusOK, rec.Code, url)
+		assert.Equal(t, subdomain, rec.Body.String(), url)
+	}
+}
+
+func TestHttp_wildcardSubdomain_prefersMoreSpecificApp(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-Subdomain")))
+	}))
+	defer backend.Close()
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tenant1 app " + r.Header.Get("X-Forwarded-Subdomain")))
+	}))
+	defer other.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+	linkTestProxyApp(t, h, "tenant1.myapp", other.URL, "")
+
+	assert.Equal(t, "tenant1 app www", serveTestRequest(h, "GET", "http://www.tenant1.myapp.test/").Body.String())
+	assert.Equal(t, "tenant2", serveTestRequest(h, "GET", "http://tenant2.myapp.test/").Body.String())
+}
+
+func TestHttp_wildcardSubdomain_ignoresClientHeader(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-Subdomain")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("X-Forwarded-Subdomain", "spoofed")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "", rec.Body.String())
+}
+
+func TestHttp_slowRequestThreshold(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.SlowRequestThreshold = 50 * time.Millisecond
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	serveTestRequest(h, "GET", "http://myapp.test/fast")
+	assert.NotContains(t, eventsString(h.Events), `"slow_request"`)
+
+	serveTestRequest(h, "GET", "http://myapp.test/slow")
+	events := eventsString(h.Events)
+	assert.Contains(t, events, `"event":"slow_request","app":"myapp","method":"GET","path":"/slow"`)
+}
+
+func eventsString(e *Events) string {
+	var buf bytes.Buffer
+	e.WriteTo(&buf)
+	return buf.String()
+}
+
+func TestHttp_events_drain(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.Events.Add("first_event")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/events?drain=true")
+
+	assert.Equal(t, "0", rec.Header().Get("X-Puma-Dev-Events-Dropped"))
+	assert.Contains(t, rec.Body.String(), `"event":"first_event"`)
+	assert.NotContains(t, eventsString(h.Events), `"event":"first_event"`)
+}
+
+func TestHttp_events_reset(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.Events.Add("first_event")
+	h.Events.Add("second_event")
+
+	rec := serveTestRequest(h, "DELETE", "http://puma-dev/events")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "{\"cleared\":2}\n", rec.Body.String())
+	assert.Empty(t, eventsString(h.Events))
+
+	h.Events.Add("third_event")
+
+	rec = serveTestRequest(h, "GET", "http://puma-dev/events")
+	assert.Contains(t, rec.Body.String(), `"event":"third_event"`)
+	assert.NotContains(t, rec.Body.String(), `"event":"first_event"`)
+}
+
+func TestHttp_events_blockDoesntStallLookups(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.Events.SetOverflowPolicy(linebuffer.Block, 5*time.Second)
+
+	for i := 0; i < linebuffer.DefaultSize; i++ {
+		h.Events.Add("filler")
+	}
+
+	linkTestProxyApp(t, h, "myapp", "http://127.0.0.1:1", "")
+
+	start := time.Now()
+
+	_, err := h.Pool.FindAppByDomainName("myapp")
+	assert.NoError(t, err)
+
+	assert.True(t, time.Since(start) < time.Second, "lookup waited for room in the events buffer")
+	assert.True(t, h.Events.Dropped() > 0)
+}
+
+func TestHttp_ignoresStaticPath_prefix(t *testing.T) {
+	assert.True(t, ignoresStaticPath("/packs", "/packs/application.js"))
+	assert.True(t, ignoresStaticPath("/packs", "/packs-test/application.js"))
+	assert.False(t, ignoresStaticPath("/packs", "/assets/application.js"))
+}
+
+func TestHttp_ignoresStaticPath_glob(t *testing.T) {
+	assert.True(t, ignoresStaticPath("/assets/*.map", "/assets/application.js.map"))
+	assert.False(t, ignoresStaticPath("/assets/*.map", "/assets/application.js"))
+	assert.False(t, ignoresStaticPath("/assets/*.map", "/assets/vendor/lib.js.map"))
+	assert.False(t, ignoresStaticPath("/assets/*.map", "/other/application.js.map"))
+}
+
+func TestHttp_ignoresStaticPath_globDirectory(t *testing.T) {
+	assert.True(t, ignoresStaticPath("/uploads/*", "/uploads/2023/photo.png"))
+	assert.True(t, ignoresStaticPath("/*/private", "/docs/private/report.pdf"))
+	assert.False(t, ignoresStaticPath("/*/private", "/docs/public/report.pdf"))
+}
+
+func TestHttp_jsonLogging(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+
+	h.JSONLogging = true
+	h.logOutput = &out
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	serveTestRequest(h, "POST", "http://myapp.test/widgets")
+
+	var entry map[string]interface{}
+
+	err := json.Unmarshal(out.Bytes(), &entry)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "POST", entry["method"])
+	assert.Equal(t, "/widgets", entry["path"])
+	assert.Equal(t, "myapp.test", entry["host"])
+	assert.Equal(t, "myapp", entry["resolved_app"])
+	assert.Equal(t, "local", entry["mode"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.NotEmpty(t, entry["timestamp"])
+	assert.NotEmpty(t, entry["duration"])
+}
+
+func TestHttp_jsonLogging_withDebug(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+
+	h.JSONLogging = true
+	h.Debug = true
+	h.logOutput = &out
+
+	serveTestRequest(h, "GET", "http://puma-dev/status")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	assert.Contains(t, lines[0], "GET '/status' (host=puma-dev)")
+	assert.True(t, json.Valid([]byte(lines[1])))
+}
+
+func TestHttp_debugProxy(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+
+	h.Debug = true
+	h.logOutput = &out
+
+	backend := namedBackend("services")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "")
+
+	serveTestRequest(h, "GET", "http://api.pco.test/services/v2/plans")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	assert.Contains(t, lines[0], "GET '/services/v2/plans' (host=api.pco.test)")
+	assert.Contains(t, lines[1], "GET '/services/v2/plans' -> app=services.pco "+backend.URL)
+}
+
+func TestHttp_logHeaders(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var out bytes.Buffer
+
+	h.logOutput = &out
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Set("X-Api-Key", "backend-key")
+		w.Header().Set("X-Request-Id", "42")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "log_headers: true\nredact_headers: [X-Api-Key]\n")
+	linkTestProxyApp(t, h, "quiet", backend.URL, "")
+
+	req := httptest.NewRequest("GET", "http://api.pco.test/services/v2/plans?page=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "client-key")
+
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	logged := out.String()
+
+	assert.Contains(t, logged, "services.pco > GET /services/v2/plans?page=2\n")
+	assert.Contains(t, logged, "  Host: api.pco.test\n")
+	assert.Contains(t, logged, "  X-Pco-Api-Engine-Host: api.pco.test\n")
+	assert.Contains(t, logged, "  Authorization: [redacted]\n")
+	assert.Contains(t, logged, "services.pco < 201 Created\n")
+	assert.Contains(t, logged, "  Set-Cookie: [redacted]\n")
+	assert.Contains(t, logged, "  X-Request-Id: 42\n")
+	assert.NotContains(t, logged, "secret")
+	assert.NotContains(t, logged, "-key")
+
+	out.Reset()
+
+	serveTestRequest(h, "GET", "http://quiet.test/")
+	assert.Empty(t, out.String())
+}
+
+func TestHttp_touchApp(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "")
+
+	rec := serveTestRequest(h, "POST", "http://puma-dev/touch/myapp")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NotContains(t, eventsString(h.Events), `"booting_app"`)
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+
+	rec = serveTestRequest(h, "POST", "http://puma-dev/touch/myapp")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"myapp","status":"running"}`, rec.Body.String())
+	assert.Contains(t, eventsString(h.Events), `"reason":"restart.txt touched"`)
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func TestHttp_stopApp(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "")
+
+	rec := serveTestRequest(h, "POST", "http://puma-dev/stop/doesnotexist")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serveTestRequest(h, "POST", "http://puma-dev/stop/myapp")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"myapp","status":"dead"}`, rec.Body.String())
+	assert.NotContains(t, eventsString(h.Events), `"booting_app"`)
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+
+	rec = serveTestRequest(h, "POST", "http://puma-dev/stop/myapp")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"myapp","status":"dead"}`, rec.Body.String())
+	assert.Contains(t, eventsString(h.Events), `"reason":"stop requested via api"`)
+
+	_, err := h.Pool.FindRunningApp("myapp")
+	assert.Equal(t, ErrAppNotRunning, err)
+}
+
+func TestHttp_restartOnBundleChange(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "restart_on_bundle_change: true\n")
+
+	lockfile := filepath.Join(h.Pool.Dir, "myapp", "Gemfile.lock")
+	assert.NoError(t, ioutil.WriteFile(lockfile, []byte("GEM\n"), 0644))
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(lockfile, later, later))
+
+	waitForEvent(t, h, `"reason":"Gemfile.lock changed"`)
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func TestHttp_touchApp_proxy(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	linkTestProxyApp(t, h, "myapp", "http://127.0.0.1:1", "")
+
+	_, err := h.Pool.FindAppByDomainName("myapp")
+	assert.NoError(t, err)
+
+	rec := serveTestRequest(h, "POST", "http://puma-dev/touch/myapp")
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestHttp_disableKeepAlives(t *testing.T) {
+	for _, disabled := range []bool{false, true} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		h.DisableKeepAlives = disabled
+		h.Setup()
+
+		var conns int32
+
+		backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		backend.Start()
+		defer backend.Close()
+
+		linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+		for i := 0; i < 3; i++ {
+			rec := serveTestRequest(h, "GET", "http://myapp.test/")
+			assert.Equal(t, "ok", rec.Body.String())
+		}
+
+		if disabled {
+			assert.Equal(t, int32(3), atomic.LoadInt32(&conns))
+		} else {
+			assert.Equal(t, int32(1), atomic.LoadInt32(&conns))
+		}
+	}
+}
+
+func TestHttp_defaultApp(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	rec := serveTestRequest(h, "GET", "http://nowhere.test/")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	var originalHost string
+
+	landing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originalHost = r.Header.Get("X-Original-Host")
+		w.Write([]byte("landing"))
+	}))
+	defer landing.Close()
+
+	linkTestProxyApp(t, h, "landing", landing.URL, "")
+
+	h.DefaultApp = "landing"
+
+	rec = serveTestRequest(h, "GET", "http://nowhere.test/")
+	assert.Equal(t, "landing", rec.Body.String())
+	assert.Equal(t, "nowhere.test", originalHost)
+	assert.Contains(t, eventsString(h.Events), `"event":"unknown_app","name":"nowhere","host":"nowhere.test","default_app":"landing"`)
+
+	rec = serveTestRequest(h, "GET", "http://landing.test/")
+	assert.Equal(t, "landing", rec.Body.String())
+	assert.Equal(t, "", originalHost)
+
+	h.DefaultApp = "missing"
+
+	rec = serveTestRequest(h, "GET", "http://nowhere.test/")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, eventsString(h.Events), `"event":"unknown_app","name":"missing"`)
+}
+
+func TestHttp_lookupError(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	linkTestProxyApp(t, h, "myapp.pco", "http://127.0.0.1:1", "stdin: tty\n")
+
+	rec := serveTestRequest(h, "GET", "http://api.pco.test/myapp/v2/widgets")
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, eventsString(h.Events),
+		`"event":"lookup_error","name":"myapp.pco","host":"api.pco.test","method":"GET","path":"/myapp/v2/widgets","error":"invalid stdin 'tty'`)
+}
+
+func TestHttp_healthz(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := namedBackend("myapp")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+	serveTestRequest(h, "GET", "http://myapp.test/")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/healthz")
+
+	var health struct {
+		Uptime string `json:"uptime"`
+		Apps   int    `json:"apps"`
+	}
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &health))
+	assert.Equal(t, "0s", health.Uptime)
+	assert.Equal(t, 1, health.Apps)
+}
+
+func TestHttp_statusUsage(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := namedBackend("myapp")
+	defer backend.Close()
+
+	plain := namedBackend("plain")
+	defer plain.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "metrics_labels:\n  team: payments\n  tier: web\n")
+	linkTestProxyApp(t, h, "plain", plain.URL, "")
+
+	start := time.Now().Truncate(time.Second)
+
+	for i := 0; i < 3; i++ {
+		serveTestRequest(h, "GET", "http://myapp.test/")
+	}
+
+	serveTestRequest(h, "GET", "http://plain.test/")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/status")
+
+	var statuses map[string]struct {
+		RequestCount int64             `json:"request_count"`
+		LastAccessed string            `json:"last_accessed"`
+		Labels       map[string]string `json:"labels"`
+	}
+
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+	assert.Equal(t, int64(3), statuses["myapp"].RequestCount)
+	assert.Equal(t, map[string]string{"team": "payments", "tier": "web"}, statuses["myapp"].Labels)
+	assert.Nil(t, statuses["plain"].Labels)
+
+	lastAccessed, err := time.Parse(time.RFC3339, statuses["myapp"].LastAccessed)
+	assert.NoError(t, err)
+	assert.False(t, lastAccessed.Before(start))
+}
+
+func TestHttp_statusProcess(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "")
+
+	backend := namedBackend("proxied")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "proxied", backend.URL, "")
+
+	serveTestRequest(h, "GET", "http://myapp.test/")
+	serveTestRequest(h, "GET", "http://proxied.test/")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/status")
+
+	var statuses map[string]struct {
+		PID         int   `json:"pid"`
+		MemoryBytes int64 `json:"memory_bytes"`
+	}
+
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+
+	app, err := h.Pool.FindRunningApp("myapp")
+	if assert.NoError(t, err) {
+		assert.Equal(t, app.Command.Process.Pid, statuses[app.Name].PID)
+		assert.True(t, statuses[app.Name].MemoryBytes > 0)
+	}
+
+	assert.Zero(t, statuses["proxied"].PID)
+	assert.Zero(t, statuses["proxied"].MemoryBytes)
+}
+
+func TestHttp_statusMemoryOutsidePoolLock(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	defer func(orig func(int) int64) { appMemory = orig }(appMemory)
+
+	appMemory = func(pid int) int64 {
+		done := make(chan struct{})
+
+		go func() {
+			h.Pool.ForApps(func(_ *App) {})
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			return 1
+		case <-time.After(time.Second):
+			return -1
+		}
+	}
+
+	makeTestApp(t, h, "myapp", "")
+	serveTestRequest(h, "GET", "http://myapp.test/")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/status")
+
+	var statuses map[string]struct {
+		MemoryBytes int64 `json:"memory_bytes"`
+	}
+
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+	assert.Equal(t, int64(1), statuses["myapp"].MemoryBytes)
+}
+
+func TestHttp_statusDirAndCommand(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "")
+
+	backend := namedBackend("proxied")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "proxied", backend.URL, "")
+
+	serveTestRequest(h, "GET", "http://myapp.test/")
+	serveTestRequest(h, "GET", "http://proxied.test/")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/status")
+
+	var statuses map[string]struct {
+		Dir     string   `json:"dir"`
+		Command []string `json:"command"`
+	}
+
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+
+	app, err := h.Pool.FindRunningApp("myapp")
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(h.Pool.Dir, "myapp"), statuses[app.Name].Dir)
+		assert.Equal(t, app.Command.Args, statuses[app.Name].Command)
+	}
+
+	assert.Empty(t, statuses["proxied"].Dir)
+	assert.Empty(t, statuses["proxied"].Command)
+}
+
+func TestHttp_stripRequestHeaders(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.StripRequestHeaders = []string{"Purpose", "x-moz"}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Purpose") + "|" + r.Header.Get("X-Moz") + "|" + r.Header.Get("Accept") + "|" + r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Purpose", "prefetch")
+	req.Header.Set("X-Moz", "prefetch")
+	req.Header.Set("Accept", "text/html")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, "||text/html|http", rec.Body.String())
+}
+
+func TestHttp_forwardedHeader(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Forwarded") + "|" + r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	tests := []struct {
+		enabled    bool
+		remoteAddr string
+		host       string
+		expected   string
+	}{
+		{false, "192.0.2.1:1234", "myapp.test", "|http"},
+		{true, "192.0.2.1:1234", "myapp.test", "for=192.0.2.1;host=myapp.test;proto=http|http"},
+		{true, "[2001:db8::1]:1234", "myapp.test:8080", `for="[2001:db8::1]";host="myapp.test:8080";proto=http|http`},
+	}
+
+	for _, tt := range tests {
+		h.UseForwardedHeader = tt.enabled
+
+		req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Host = tt.host
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		assert.Equal(t, tt.expected, rec.Body.String())
+	}
+}
+
+func TestHttp_pcoRoutingDomains(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.Domains = []string{"test", "localhost"}
+	h.Setup()
+
+	for _, name := range []string{"api.pco", "services.pco", "churchcenter", "giving.pco"} {
+		backend := namedBackend(name)
+		defer backend.Close()
+
+		linkTestProxyApp(t, h, name, backend.URL, "")
+	}
+
+	cases := map[string]string{
+		"http://api.pco.test/":                          "api.pco",
+		"http://api.pco.localhost/services/v2/plans":    "services.pco",
+		"http://api.churchcenter.localhost/global/v2":   "api.pco",
+		"http://demo.churchcenter.localhost/giving":     "giving.pco",
+		"http://demo.churchcenter.localhost/":           "churchcenter",
+		"http://api.pco.10.0.0.1.nip.io/services/v2/me": "api.pco",
+	}
+
+	for url, app := range cases {
+		rec := serveTestRequest(h, "GET", url)
+		assert.Equal(t, app, strings.SplitN(rec.Body.String(), " ", 2)[0], url)
+	}
+
+	// .codes isn't one of the domains, so it gets no special routing.
+	rec := serveTestRequest(h, "GET", "http://api.pco.codes/services/v2/plans")
+	assert.Equal(t, "api.pco", strings.SplitN(rec.Body.String(), " ", 2)[0])
+}
+
+func TestHttp_engineHost(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-PCO-API-Engine-Host")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "")
+
+	tests := []struct {
+		trusted  bool
+		client   string
+		expected string
+	}{
+		{false, "", "api.pco.test"},
+		{false, "api.pco.codes", "api.pco.test"},
+		{true, "", "api.pco.test"},
+		{true, "api.pco.codes", "api.pco.codes"},
+	}
+
+	for _, tt := range tests {
+		h.TrustEngineHostHeader = tt.trusted
+
+		for _, url := range []string{"http://api.pco.test/services/v2/plans", "http://api.pco.test/~api/services/v2/plans"} {
+			req := httptest.NewRequest("GET", url, nil)
+			if tt.client != "" {
+				req.Header.Set("X-PCO-API-Engine-Host", tt.client)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expected, rec.Body.String(), "%s trusted=%v", url, tt.trusted)
+		}
+	}
+}
+
+func TestHttp_engineHostHeaderName(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.EngineHostHeader = "X-Engine-Host"
+	h.Setup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Engine-Host") + "|" + r.Header.Get("X-PCO-API-Engine-Host")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://api.pco.test/services/v2/plans")
+	assert.Equal(t, "api.pco.test|", rec.Body.String())
+}
+
+func TestHttp_socketPathTooLong(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	dir, cleanupDir := testTempDir(t)
+	defer cleanupDir()
+
+	socket := filepath.Join(dir, strings.Repeat("d", maxSocketPathLen), "puma-dev.sock")
+
+	_, err := h.unixTransport.DialContext(context.Background(), "tcp", net.JoinHostPort(socket, "80"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "move the app somewhere with a shorter path")
+	}
+
+	assert.Contains(t, eventsString(h.Events), `"event":"socket_path_too_long"`)
+
+	// Paths that fit fail the usual way.
+	socket = filepath.Join(dir, "puma-dev.sock")
+
+	_, err = h.unixTransport.DialContext(context.Background(), "tcp", net.JoinHostPort(socket, "80"))
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "shorter path")
+	}
+}
@@ -0,0 +1,86 @@
+package dev
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// inflightRequests tracks the requests being proxied, to find ones stuck on
+// a slow app.
+type inflightRequests struct {
+	lock     sync.Mutex
+	seq      uint64
+	requests map[uint64]*inflightRequest
+}
+
+type inflightRequest struct {
+	Method  string `json:"method"`
+	Host    string `json:"host"`
+	Path    string `json:"path"`
+	App     string `json:"app"`
+	Started string `json:"started"`
+	Elapsed string `json:"elapsed"`
+
+	started time.Time
+}
+
+// track records req as in flight to app, until the returned func is called.
+func (ir *inflightRequests) track(req *http.Request, app string) func() {
+	ir.lock.Lock()
+	defer ir.lock.Unlock()
+
+	if ir.requests == nil {
+		ir.requests = make(map[uint64]*inflightRequest)
+	}
+
+	ir.seq++
+	id := ir.seq
+
+	ir.requests[id] = &inflightRequest{
+		Method:  req.Method,
+		Host:    req.Host,
+		Path:    req.URL.Path,
+		App:     app,
+		started: time.Now(),
+	}
+
+	return func() {
+		ir.lock.Lock()
+		defer ir.lock.Unlock()
+
+		delete(ir.requests, id)
+	}
+}
+
+// snapshot returns the requests in flight, oldest first.
+func (ir *inflightRequests) snapshot() []inflightRequest {
+	ir.lock.Lock()
+	defer ir.lock.Unlock()
+
+	now := time.Now()
+
+	requests := make([]inflightRequest, 0, len(ir.requests))
+
+	for _, r := range ir.requests {
+		entry := *r
+		entry.Started = r.started.Format(time.RFC3339)
+		entry.Elapsed = now.Sub(r.started).Round(time.Millisecond).String()
+
+		requests = append(requests, entry)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].started.Before(requests[j].started)
+	})
+
+	return requests
+}
+
+// inflightRequests lists the requests being proxied and how long they've taken.
+func (h *HTTPServer) inflightRequests(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.inflight.snapshot())
+}
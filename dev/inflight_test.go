@@ -0,0 +1,71 @@
+package dev
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func inflightSnapshot(t *testing.T, h *HTTPServer) []inflightRequest {
+	rec := serveTestRequest(h, "GET", "http://puma-dev/inflight")
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var requests []inflightRequest
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &requests))
+
+	return requests
+}
+
+func TestHttp_inflight(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := slowBackend(500 * time.Millisecond)
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "slow", backend.URL, "")
+
+	assert.Empty(t, inflightSnapshot(t, h))
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		serveTestRequest(h, "POST", "http://slow.test/report")
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(inflightSnapshot(t, h)) == 1
+	}, 5*time.Second, 20*time.Millisecond)
+
+	r := inflightSnapshot(t, h)[0]
+	assert.Equal(t, "POST", r.Method)
+	assert.Equal(t, "slow.test", r.Host)
+	assert.Equal(t, "/report", r.Path)
+	assert.Equal(t, "slow", r.App)
+	assert.NotEmpty(t, r.Started)
+	assert.NotEmpty(t, r.Elapsed)
+
+	<-done
+
+	assert.Empty(t, inflightSnapshot(t, h))
+}
+
+func TestInflightRequests_panic(t *testing.T) {
+	var ir inflightRequests
+
+	func() {
+		defer func() { recover() }()
+		defer ir.track(httptest.NewRequest("GET", "http://myapp.test/", nil), "myapp")()
+
+		assert.Len(t, ir.snapshot(), 1)
+
+		panic(http.ErrAbortHandler)
+	}()
+
+	assert.Empty(t, ir.snapshot())
+}
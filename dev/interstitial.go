@@ -0,0 +1,83 @@
+package dev
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// bootStatusPrefix is where the boot interstitial polls for the app's
+// status, on the app's own host.
+const bootStatusPrefix = "/.puma-dev/status/"
+
+var bootInterstitialPage = template.Must(template.New("boot").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Booting {{.Name}}</title>
+<style>body { font: 16px -apple-system, sans-serif; color: #333; max-width: 40em; margin: 4em auto; }</style>
+</head>
+<body>
+<h1>Booting {{.Name}}&hellip;</h1>
+<p id="status">This page reloads once the app is ready.</p>
+<script>
+(function poll() {
+  fetch({{.StatusURL}}, {cache: "no-store"}).then(function(resp) {
+    return resp.ok ? resp.json() : {status: "dead"};
+  }).then(function(app) {
+    if (app.status === "booting") {
+      setTimeout(poll, 500);
+    } else if (app.status === "running") {
+      location.reload();
+    } else {
+      document.getElementById("status").textContent = "The app failed to boot, reload to try again.";
+    }
+  }, function() {
+    setTimeout(poll, 1000);
+  });
+})();
+</script>
+</body>
+</html>
+`))
+
+// serveBootInterstitial answers browsers asking for a booting app with a
+// page that waits for it, rather than holding the request. It reports
+// whether it did.
+func (h *HTTPServer) serveBootInterstitial(w http.ResponseWriter, req *http.Request, app *App) bool {
+	if !h.BootInterstitial || req.Method != http.MethodGet || app.Status() != Booting {
+		return false
+	}
+
+	if !strings.Contains(req.Header.Get("Accept"), "text/html") {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	bootInterstitialPage.Execute(w, struct {
+		Name      string
+		StatusURL string
+	}{app.Name, bootStatusPrefix + url.PathEscape(app.Name)})
+
+	return true
+}
+
+// serveBootStatus answers the interstitial's polls, reporting whether the
+// request was one.
+func (h *HTTPServer) serveBootStatus(w http.ResponseWriter, req *http.Request) bool {
+	if !h.BootInterstitial || req.Method != http.MethodGet || !strings.HasPrefix(req.URL.Path, bootStatusPrefix) {
+		return false
+	}
+
+	req.URL.RawQuery = url.Values{":name": {strings.TrimPrefix(req.URL.Path, bootStatusPrefix)}}.Encode()
+
+	w.Header().Set("Cache-Control", "no-store")
+	h.singleStatus(w, req)
+
+	return true
+}
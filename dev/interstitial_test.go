@@ -0,0 +1,60 @@
+package dev
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttp_bootInterstitial(t *testing.T) {
+	defer helperAppCommand(time.Second, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.BootInterstitial = true
+
+	makeTestApp(t, h, "myapp", "")
+
+	browse := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	status := func() string {
+		rec := serveTestRequest(h, "GET", "http://myapp.test/.puma-dev/status/myapp")
+
+		var s appStatus
+		json.Unmarshal(rec.Body.Bytes(), &s)
+
+		return s.Status
+	}
+
+	start := time.Now()
+	rec := browse()
+
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "the page waited for the app")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Booting myapp")
+	assert.Contains(t, rec.Body.String(), `fetch("/.puma-dev/status/myapp"`)
+	assert.Equal(t, "booting", status())
+
+	// Other clients still wait for the app.
+	rec = serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+
+	assert.Equal(t, "running", status())
+	assert.Equal(t, "ok", browse().Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/.puma-dev/status/other")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,127 @@
+package dev
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipFilter decides which client IPs may use the proxy. A nil filter lets
+// everyone in.
+type ipFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+
+	// Set when the configured ranges couldn't be parsed, refusing everyone
+	// rather than guessing.
+	invalid bool
+}
+
+// ParseCIDRs parses ranges such as 10.0.0.0/8, taking a bare IP to be a
+// range of one.
+func ParseCIDRs(specs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR '%s'", spec)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR '%s'", spec)
+		}
+
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func newIPFilter(allowed, denied []string) *ipFilter {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil
+	}
+
+	f := &ipFilter{}
+
+	var err error
+
+	f.allowed, err = ParseCIDRs(allowed)
+	if err == nil {
+		f.denied, err = ParseCIDRs(denied)
+	}
+
+	if err != nil {
+		fmt.Printf("! Refusing all clients, %s\n", err)
+		f.invalid = true
+	}
+
+	return f
+}
+
+// allows reports whether req's client may be served. Behind the PROXY
+// protocol RemoteAddr is already the original client.
+func (f *ipFilter) allows(req *http.Request) bool {
+	if f.invalid {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range f.denied {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(f.allowed) == 0 {
+		return true
+	}
+
+	for _, n := range f.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rejectClient answers with a 403 if req's client isn't allowed in.
+func (h *HTTPServer) rejectClient(w http.ResponseWriter, req *http.Request) bool {
+	if h.ipFilter == nil || h.ipFilter.allows(req) {
+		return false
+	}
+
+	h.Events.Add("client_denied", "remote_addr", req.RemoteAddr, "host", req.Host)
+
+	http.Error(w, "your address is not allowed to use this proxy", http.StatusForbidden)
+
+	return true
+}
@@ -0,0 +1,79 @@
+package dev
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", " 192.168.1.5 ", "::1", ""})
+	if assert.NoError(t, err) && assert.Len(t, nets, 3) {
+		assert.Equal(t, "10.0.0.0/8", nets[0].String())
+		assert.Equal(t, "192.168.1.5/32", nets[1].String())
+		assert.Equal(t, "::1/128", nets[2].String())
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "localhost", "10.0.0"} {
+		_, err := ParseCIDRs([]string{bad})
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestHttp_ipFilter(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.AllowedCIDRs = []string{"10.0.0.0/8", "::1"}
+	h.DeniedCIDRs = []string{"10.0.0.66"}
+	h.Setup()
+
+	backend := namedBackend("myapp")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	serveFrom := func(remoteAddr, url string) int {
+		req := httptest.NewRequest("GET", url, nil)
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serveFrom("10.1.2.3:5000", "http://myapp.test/"))
+	assert.Equal(t, http.StatusOK, serveFrom("[::1]:5000", "http://myapp.test/"))
+	assert.Equal(t, http.StatusForbidden, serveFrom("10.0.0.66:5000", "http://myapp.test/"))
+	assert.Equal(t, http.StatusForbidden, serveFrom("192.0.2.1:5000", "http://myapp.test/"))
+	assert.Equal(t, http.StatusForbidden, serveFrom("192.0.2.1:5000", "http://nowhere.test/"))
+
+	assert.Contains(t, eventsString(h.Events), `"event":"client_denied","remote_addr":"10.0.0.66:5000","host":"myapp.test"`)
+	assert.NotContains(t, eventsString(h.Events), `"name":"nowhere"`)
+
+	assert.Equal(t, http.StatusOK, serveFrom("192.0.2.1:5000", "http://puma-dev/status"))
+
+	h.FilterAdmin = true
+
+	assert.Equal(t, http.StatusForbidden, serveFrom("192.0.2.1:5000", "http://puma-dev/status"))
+	assert.Equal(t, http.StatusOK, serveFrom("10.1.2.3:5000", "http://puma-dev/status"))
+}
+
+func TestHttp_ipFilter_invalid(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.DeniedCIDRs = []string{"10.0.0.0/33"}
+	h.Setup()
+
+	req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.RemoteAddr = "192.0.2.1:5000"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,259 @@
+package dev
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vektra/errors"
+)
+
+var ErrQueueFull = errors.New("too many concurrent requests")
+
+const (
+	// defaultAdaptiveMax bounds adaptive limiters for apps that don't set
+	// max_concurrency.
+	defaultAdaptiveMax = 100
+
+	// defaultAdaptiveStart is the limit adaptive limiters begin with.
+	defaultAdaptiveStart = 10
+
+	// DefaultLatencyTarget is the response time above which an adaptive
+	// limiter starts lowering an app's concurrency.
+	DefaultLatencyTarget = 1 * time.Second
+
+	// adaptiveBackoff is the factor the limit is multiplied by whenever a
+	// request exceeds the latency target.
+	adaptiveBackoff = 0.9
+)
+
+type requestLimiter interface {
+	Acquire(ctx context.Context) error
+	Release(elapsed time.Duration)
+	Limit() int
+}
+
+// concurrencyLimiter bounds the number of in-flight requests to an app,
+// holding up to queueSize extra requests until a slot frees up.
+type concurrencyLimiter struct {
+	max       int
+	queueSize int
+
+	slots chan struct{}
+	queue chan struct{}
+}
+
+func newConcurrencyLimiter(max, queueSize int) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		max:       max,
+		queueSize: queueSize,
+		slots:     make(chan struct{}, max),
+		queue:     make(chan struct{}, queueSize),
+	}
+}
+
+func (l *concurrencyLimiter) Acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return ErrQueueFull
+	}
+
+	defer func() { <-l.queue }()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *concurrencyLimiter) Release(_ time.Duration) {
+	<-l.slots
+}
+
+func (l *concurrencyLimiter) Limit() int {
+	return l.max
+}
+
+// adaptiveLimiter adjusts the concurrency limit AIMD style on response times.
+type adaptiveLimiter struct {
+	min       int
+	max       int
+	target    time.Duration
+	queueSize int
+
+	lock     sync.Mutex
+	limit    float64
+	inflight int
+	waiting  int
+	changed  chan struct{}
+}
+
+func newAdaptiveLimiter(max, queueSize int, target time.Duration) *adaptiveLimiter {
+	start := defaultAdaptiveStart
+	if start > max {
+		start = max
+	}
+
+	return &adaptiveLimiter{
+		min:       1,
+		max:       max,
+		target:    target,
+		queueSize: queueSize,
+		limit:     float64(start),
+		changed:   make(chan struct{}),
+	}
+}
+
+func (l *adaptiveLimiter) Acquire(ctx context.Context) error {
+	l.lock.Lock()
+
+	queued := false
+
+	for l.inflight >= int(l.limit) {
+		if !queued {
+			if l.waiting >= l.queueSize {
+				l.lock.Unlock()
+				return ErrQueueFull
+			}
+
+			l.waiting++
+			queued = true
+		}
+
+		changed := l.changed
+		l.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			l.lock.Lock()
+			l.waiting--
+			l.lock.Unlock()
+			return ctx.Err()
+		}
+
+		l.lock.Lock()
+	}
+
+	if queued {
+		l.waiting--
+	}
+
+	l.inflight++
+	l.lock.Unlock()
+
+	return nil
+}
+
+func (l *adaptiveLimiter) Release(elapsed time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.inflight--
+
+	if elapsed > l.target {
+		l.limit *= adaptiveBackoff
+		if l.limit < float64(l.min) {
+			l.limit = float64(l.min)
+		}
+	} else {
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.max) {
+			l.limit = float64(l.max)
+		}
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+func (l *adaptiveLimiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return int(l.limit)
+}
+
+type appLimiters struct {
+	lock     sync.Mutex
+	limiters map[string]*appLimiter
+}
+
+type appLimiter struct {
+	requestLimiter
+	cfg AppConfig
+}
+
+// limiterFor returns nil for unlimited apps, rebuilding it when the config changed.
+func (al *appLimiters) limiterFor(app *App) requestLimiter {
+	cfg := app.Config
+
+	if cfg.MaxConcurrency <= 0 && !cfg.AdaptiveConcurrency {
+		return nil
+	}
+
+	al.lock.Lock()
+	defer al.lock.Unlock()
+
+	if al.limiters == nil {
+		al.limiters = make(map[string]*appLimiter)
+	}
+
+	l, ok := al.limiters[app.Name]
+	if ok && l.cfg.MaxConcurrency == cfg.MaxConcurrency &&
+		l.cfg.QueueSize == cfg.QueueSize &&
+		l.cfg.AdaptiveConcurrency == cfg.AdaptiveConcurrency &&
+		l.cfg.LatencyTarget == cfg.LatencyTarget {
+		return l
+	}
+
+	queueSize := cfg.QueueSize
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	l = &appLimiter{cfg: cfg}
+
+	if cfg.AdaptiveConcurrency {
+		max := cfg.MaxConcurrency
+		if max <= 0 {
+			max = defaultAdaptiveMax
+		}
+
+		target := cfg.LatencyTarget
+		if target <= 0 {
+			target = DefaultLatencyTarget
+		}
+
+		l.requestLimiter = newAdaptiveLimiter(max, queueSize, target)
+	} else {
+		l.requestLimiter = newConcurrencyLimiter(cfg.MaxConcurrency, queueSize)
+	}
+
+	al.limiters[app.Name] = l
+
+	return l
+}
+
+// currentLimit reports the concurrency limit in effect for the named app,
+// or zero if it is unlimited.
+func (al *appLimiters) currentLimit(name string) int {
+	al.lock.Lock()
+	l, ok := al.limiters[name]
+	al.lock.Unlock()
+
+	if !ok {
+		return 0
+	}
+
+	return l.Limit()
+}
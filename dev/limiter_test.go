@@ -0,0 +1,193 @@
+package dev
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttp_maxConcurrency_unlimitedByDefault(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "unlimited", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://unlimited.test/")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func TestHttp_maxConcurrency_rejectsOverflow(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "limited", backend.URL, "max_concurrency: 1\nqueue_size: 0\n")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serveTestRequest(h, "GET", "http://limited.test/")
+	}()
+
+	<-started
+
+	rec := serveTestRequest(h, "GET", "http://limited.test/")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	close(release)
+
+	rec = <-done
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestConcurrencyLimiter_queuesUntilSlotFree(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1)
+
+	assert.NoError(t, l.Acquire(context.Background()))
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- l.Acquire(context.Background())
+	}()
+
+	assert.Eventually(t, func() bool {
+		return len(l.queue) == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Equal(t, ErrQueueFull, l.Acquire(context.Background()))
+
+	l.Release(0)
+
+	assert.NoError(t, <-acquired)
+	assert.Equal(t, 0, len(l.queue))
+}
+
+func TestConcurrencyLimiter_queuedRequestCanceled(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1)
+
+	assert.NoError(t, l.Acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, l.Acquire(ctx))
+	assert.Equal(t, 0, len(l.queue))
+}
+
+func TestAdaptiveLimiter_lowersLimitWhenSlow(t *testing.T) {
+	l := newAdaptiveLimiter(20, 0, 100*time.Millisecond)
+
+	before := l.Limit()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.Acquire(context.Background()))
+		l.Release(time.Second)
+	}
+
+	assert.True(t, l.Limit() < before, "limit should drop, was %d now %d", before, l.Limit())
+}
+
+func TestAdaptiveLimiter_raisesLimitWhenFast(t *testing.T) {
+	l := newAdaptiveLimiter(20, 0, 100*time.Millisecond)
+
+	before := l.Limit()
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, l.Acquire(context.Background()))
+		l.Release(time.Millisecond)
+	}
+
+	assert.True(t, l.Limit() > before, "limit should grow, was %d now %d", before, l.Limit())
+}
+
+func TestAdaptiveLimiter_staysWithinBounds(t *testing.T) {
+	l := newAdaptiveLimiter(12, 0, 100*time.Millisecond)
+
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, l.Acquire(context.Background()))
+		l.Release(time.Millisecond)
+	}
+
+	assert.Equal(t, 12, l.Limit())
+
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, l.Acquire(context.Background()))
+		l.Release(time.Second)
+	}
+
+	assert.Equal(t, 1, l.Limit())
+}
+
+func TestAdaptiveLimiter_rejectsOverLimit(t *testing.T) {
+	l := newAdaptiveLimiter(1, 0, 100*time.Millisecond)
+
+	assert.NoError(t, l.Acquire(context.Background()))
+	assert.Equal(t, ErrQueueFull, l.Acquire(context.Background()))
+
+	l.Release(time.Millisecond)
+
+	assert.NoError(t, l.Acquire(context.Background()))
+}
+
+func TestHttp_adaptiveConcurrency_status(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var delay int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(atomic.LoadInt64(&delay)))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "fragile", backend.URL, "adaptive_concurrency: true\nmax_concurrency: 20\nlatency_target: 20ms\n")
+
+	limit := func() int {
+		var statuses map[string]struct {
+			ConcurrencyLimit int `json:"concurrency_limit"`
+		}
+
+		rec := serveTestRequest(h, "GET", "http://puma-dev/status")
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+
+		return statuses["fragile"].ConcurrencyLimit
+	}
+
+	serveTestRequest(h, "GET", "http://fragile.test/")
+	start := limit()
+
+	atomic.StoreInt64(&delay, int64(40*time.Millisecond))
+	for i := 0; i < 5; i++ {
+		serveTestRequest(h, "GET", "http://fragile.test/")
+	}
+
+	slowed := limit()
+	assert.True(t, slowed < start, "limit should drop, was %d now %d", start, slowed)
+
+	atomic.StoreInt64(&delay, 0)
+	for i := 0; i < 50; i++ {
+		serveTestRequest(h, "GET", "http://fragile.test/")
+	}
+
+	assert.True(t, limit() > slowed, "limit should recover, was %d now %d", slowed, limit())
+}
@@ -0,0 +1,126 @@
+package dev
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRedactedHeaders have their values hidden in log_headers output.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// requestLog is the line written per request when JSONLogging is enabled.
+// Mode is whether the app ran locally or has an upstream_url.
+type requestLog struct {
+	Timestamp   string `json:"timestamp"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Host        string `json:"host"`
+	ResolvedApp string `json:"resolved_app,omitempty"`
+	Mode        string `json:"mode,omitempty"`
+	Status      int    `json:"status"`
+	Duration    string `json:"duration"`
+
+	start time.Time
+	cw    *captureWriter
+}
+
+var logLock sync.Mutex
+
+// startRequestLog begins the JSON log entry for req, returning the writer
+// the response should go through so its status can be logged.
+func (h *HTTPServer) startRequestLog(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, *requestLog) {
+	entry := &requestLog{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Host:   req.Host,
+		start:  time.Now(),
+		cw:     newCaptureWriter(w, false),
+	}
+
+	entry.Timestamp = entry.start.Format(time.RFC3339Nano)
+
+	return entry.cw, entry
+}
+
+func (h *HTTPServer) finishRequestLog(entry *requestLog) {
+	entry.Status = entry.cw.Status()
+	entry.Duration = time.Since(entry.start).String()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	logLock.Lock()
+	defer logLock.Unlock()
+
+	h.logWriter().Write(append(data, '\n'))
+}
+
+// logWriter returns where per-request log lines go, stderr unless a test
+// says otherwise.
+func (h *HTTPServer) logWriter() io.Writer {
+	if h.logOutput == nil {
+		return os.Stderr
+	}
+
+	return h.logOutput
+}
+
+// logRequestHeaders shows the headers req goes to an app with log_headers
+// with. Host is the one actually sent, which a Host header set by the PCO
+// rewrites doesn't change.
+func (h *HTTPServer) logRequestHeaders(req *http.Request, app *App) {
+	if !app.Config.LogHeaders {
+		return
+	}
+
+	header := req.Header.Clone()
+	header.Set("Host", req.Host)
+
+	h.logHeaders(app, fmt.Sprintf("> %s %s", req.Method, req.URL.RequestURI()), header)
+}
+
+func (h *HTTPServer) logResponseHeaders(resp *http.Response) {
+	app, ok := resp.Request.Context().Value(appContextKey).(*App)
+	if !ok || !app.Config.LogHeaders {
+		return
+	}
+
+	h.logHeaders(app, "< "+resp.Status, resp.Header)
+}
+
+func (h *HTTPServer) logHeaders(app *App, summary string, header http.Header) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "%s: %s %s\n", time.Now().Format(time.RFC3339Nano), app.Name, summary)
+
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, value := range header[name] {
+			if app.Config.redactsHeader(name) {
+				value = "[redacted]"
+			}
+
+			fmt.Fprintf(&buf, "  %s: %s\n", name, value)
+		}
+	}
+
+	logLock.Lock()
+	defer logLock.Unlock()
+
+	h.logWriter().Write(buf.Bytes())
+}
@@ -0,0 +1,220 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// DefaultLogMaxSize is the size an app's log file may reach before it
+	// is rotated, when log_max_size isn't set.
+	DefaultLogMaxSize = 10 * 1024 * 1024
+
+	// DefaultLogMaxFiles is how many rotated log files are kept, when
+	// log_max_files isn't set.
+	DefaultLogMaxFiles = 5
+)
+
+// rotatingFile rotates to path.1, path.2, ... past maxSize, keeping maxFiles.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	lock sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultLogMaxSize
+	}
+
+	if maxFiles <= 0 {
+		maxFiles = DefaultLogMaxFiles
+	}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	rf := &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+
+	err = rf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f = f
+	rf.size = stat.Size()
+
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		err := rf.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	err := rf.f.Close()
+	rf.f = nil
+
+	if err != nil {
+		return err
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxFiles))
+
+	for i := rf.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+
+	err = os.Rename(rf.path, rf.path+".1")
+	if err != nil {
+		return err
+	}
+
+	return rf.open()
+}
+
+// logFiles is the contents of several log files read back to back.
+type logFiles struct {
+	io.Reader
+	files []*os.File
+}
+
+func (l *logFiles) Close() error {
+	for _, f := range l.files {
+		f.Close()
+	}
+
+	return nil
+}
+
+// reader returns the contents of the rotated files, oldest first, followed
+// by the current file.
+func (rf *rotatingFile) reader() io.ReadCloser {
+	var (
+		files   []*os.File
+		readers []io.Reader
+	)
+
+	for i := rf.maxFiles; i >= 0; i-- {
+		path := rf.path
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", rf.path, i)
+		}
+
+		f, err := os.Open(path)
+		if err == nil {
+			files = append(files, f)
+			readers = append(readers, f)
+		}
+	}
+
+	return &logFiles{io.MultiReader(readers...), files}
+}
+
+// writeLogLines copies r to w, or only its last tail lines if tail isn't
+// negative.
+func writeLogLines(w io.Writer, r io.Reader, tail int) error {
+	if tail < 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	if tail == 0 {
+		return nil
+	}
+
+	lines := make([]string, tail)
+	count := 0
+
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			lines[count%tail] = line
+			count++
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	start := 0
+	if count > tail {
+		start = count - tail
+	}
+
+	for i := start; i < count; i++ {
+		_, err := io.WriteString(w, lines[i%tail])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+
+	err := rf.f.Close()
+	rf.f = nil
+
+	return err
+}
@@ -0,0 +1,163 @@
+package dev
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingFile_rotates(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "log", "app.log")
+
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer rf.Close()
+
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n", "line5\n"} {
+		_, err := rf.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+
+	read := func(path string) string {
+		data, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+		return string(data)
+	}
+
+	assert.Equal(t, "line5\n", read(path))
+	assert.Equal(t, "line4\n", read(path+".1"))
+	assert.Equal(t, "line3\n", read(path+".2"))
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotatingFile_appendsToExisting(t *testing.T) {
+	dir, cleanup := testTempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "app.log")
+
+	ioutil.WriteFile(path, []byte("old\n"), 0644)
+
+	rf, err := openRotatingFile(path, 8, 1)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	rf.Write([]byte("new\n"))
+	rf.Write([]byte("newer\n"))
+	rf.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal(t, "newer\n", string(data))
+
+	data, _ = ioutil.ReadFile(path + ".1")
+	assert.Equal(t, "old\nnew\n", string(data))
+}
+
+func TestHttp_appLogFile(t *testing.T) {
+	defer helperAppCommand(0, 1)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "launch_retries: 1\nlaunch_retry_backoff: 10ms\nlog_file: log/development.log\n")
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+
+	data, err := ioutil.ReadFile(filepath.Join(h.Pool.Dir, "myapp", "log", "development.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "launch 1 failed\n", string(data))
+}
+
+func TestWriteLogLines_tail(t *testing.T) {
+	var buf bytes.Buffer
+
+	writeLogLines(&buf, strings.NewReader("one\ntwo\nthree\nfour"), 2)
+	assert.Equal(t, "three\nfour", buf.String())
+
+	buf.Reset()
+	writeLogLines(&buf, strings.NewReader("one\ntwo\n"), 5)
+	assert.Equal(t, "one\ntwo\n", buf.String())
+
+	buf.Reset()
+	writeLogLines(&buf, strings.NewReader("one\ntwo\n"), -1)
+	assert.Equal(t, "one\ntwo\n", buf.String())
+}
+
+func TestHttp_appLog(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "log_file: log/development.log\nlog_max_size: 12\n")
+
+	rf, err := openRotatingFile(filepath.Join(h.Pool.Dir, "myapp", "log", "development.log"), 12, 0)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+		rf.Write([]byte(line))
+	}
+
+	rf.Close()
+
+	defer helperAppCommand(0, 0)()
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/log/myapp")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.NotContains(t, eventsString(h.Events), `"booting_app"`)
+
+	serveTestRequest(h, "GET", "http://myapp.test/")
+
+	rec = serveTestRequest(h, "GET", "http://puma-dev/log/myapp")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "file", rec.Header().Get("X-Puma-Dev-Log-Source"))
+	assert.Equal(t, "line1\nline2\nline3\nline4\n", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://puma-dev/log/myapp?tail=3")
+	assert.Equal(t, "line2\nline3\nline4\n", rec.Body.String())
+}
+
+func TestHttp_appLog_buffer(t *testing.T) {
+	defer helperAppCommand(0, 1)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "myapp", "launch_retries: 1\nlaunch_retry_backoff: 10ms\n")
+
+	serveTestRequest(h, "GET", "http://myapp.test/")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/log/myapp")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "buffer", rec.Header().Get("X-Puma-Dev-Log-Source"))
+	assert.Empty(t, rec.Header().Get("X-Puma-Dev-Log-Truncated"))
+	assert.Contains(t, rec.Body.String(), "launch 1 failed\n")
+}
+
+func TestHttp_appLog_unknown(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/log/missing")
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,64 @@
+package dev
+
+import (
+	"net/http"
+	"strings"
+)
+
+// PathRoute sends requests under Prefix to App when their host matches no
+// app, e.g. localhost/billing/ to billing where there's no DNS for
+// subdomains.
+type PathRoute struct {
+	Prefix string
+	App    string
+
+	// Take Prefix off the path before passing the request on.
+	Strip bool
+}
+
+// matches reports whether path is Prefix or below it, so /app1 doesn't
+// catch /app10.
+func (pr PathRoute) matches(path string) bool {
+	prefix := strings.TrimSuffix(pr.Prefix, "/")
+
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// pathRoute returns the PathRoute for path, the longest prefix winning
+// where several match.
+func (h *HTTPServer) pathRoute(path string) (PathRoute, bool) {
+	var (
+		best  PathRoute
+		found bool
+	)
+
+	for _, pr := range h.PathRoutes {
+		if pr.matches(path) && (!found || len(pr.Prefix) > len(best.Prefix)) {
+			best, found = pr, true
+		}
+	}
+
+	return best, found
+}
+
+// routeByPath applies the PathRoute for req's path, returning the app it
+// names.
+func (h *HTTPServer) routeByPath(req *http.Request) (string, bool) {
+	pr, ok := h.pathRoute(req.URL.Path)
+	if !ok {
+		return "", false
+	}
+
+	h.Events.Add("path_route", "host", req.Host, "path", req.URL.Path, "prefix", pr.Prefix, "app", pr.App)
+
+	if pr.Strip {
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, strings.TrimSuffix(pr.Prefix, "/"))
+		req.URL.RawPath = ""
+
+		if req.URL.Path == "" {
+			req.URL.Path = "/"
+		}
+	}
+
+	return pr.App, true
+}
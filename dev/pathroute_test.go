@@ -0,0 +1,74 @@
+package dev
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pathBackend answers with its name and the path it was asked for.
+func pathBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + r.URL.RequestURI()))
+	}))
+}
+
+func TestHttp_pathRoutes(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	for _, name := range []string{"app1", "admin", "myapp"} {
+		backend := pathBackend(name)
+		defer backend.Close()
+
+		linkTestProxyApp(t, h, name, backend.URL, "")
+	}
+
+	h.PathRoutes = []PathRoute{
+		{Prefix: "/app1", App: "app1"},
+		{Prefix: "/app1/admin/", App: "admin", Strip: true},
+	}
+
+	tests := []struct {
+		url, body string
+	}{
+		{"http://localhost/app1/users?page=2", "app1 /app1/users?page=2"},
+		{"http://localhost/app1", "app1 /app1"},
+		{"http://localhost/app1/admin/users", "admin /users"},
+		{"http://localhost/app1/admin", "admin /"},
+		{"http://localhost/app1/administrators", "app1 /app1/administrators"},
+		{"http://myapp.test/app1/admin/users", "myapp /app1/admin/users"},
+	}
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, "GET", tt.url)
+		assert.Equal(t, tt.body, rec.Body.String(), tt.url)
+	}
+
+	assert.Contains(t, eventsString(h.Events),
+		`"event":"path_route","host":"localhost","path":"/app1/admin/users","prefix":"/app1/admin/","app":"admin"`)
+
+	rec := serveTestRequest(h, "GET", "http://localhost/app10")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
+
+func TestHttp_pathRoutes_defaultApp(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	landing := pathBackend("landing")
+	defer landing.Close()
+
+	linkTestProxyApp(t, h, "landing", landing.URL, "")
+
+	h.PathRoutes = []PathRoute{{Prefix: "/missing", App: "missing"}}
+	h.DefaultApp = "landing"
+
+	rec := serveTestRequest(h, "GET", "http://localhost/other")
+	assert.Equal(t, "landing /other", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://localhost/missing/page")
+	assert.Equal(t, "landing /missing/page", rec.Body.String())
+}
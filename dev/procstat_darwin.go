@@ -0,0 +1,24 @@
+package dev
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// processMemory returns the resident memory of process pid in bytes, zero
+// if it can't be read.
+func processMemory(pid int) int64 {
+	out, err := exec.Command("ps", "-o", "rss=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return 0
+	}
+
+	// ps reports the resident set size in kilobytes.
+	kb, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return kb * 1024
+}
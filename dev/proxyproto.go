@@ -0,0 +1,129 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyProtoHeaderTimeout bounds how long a connection may take to send its
+// PROXY protocol header.
+const proxyProtoHeaderTimeout = 5 * time.Second
+
+// proxyProtoMaxHeader is the longest valid PROXY protocol v1 header,
+// including the trailing CRLF.
+const proxyProtoMaxHeader = 107
+
+// proxyProtoListener wraps accepted connections so that they consume a
+// PROXY protocol v1 header and report the client address it carries.
+type proxyProtoListener struct {
+	net.Listener
+}
+
+func newProxyProtoListener(l net.Listener) net.Listener {
+	return &proxyProtoListener{l}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtoConn{Conn: c, r: bufio.NewReaderSize(c, proxyProtoMaxHeader)}, nil
+}
+
+// proxyProtoConn reads the PROXY header lazily, on the first Read or
+// RemoteAddr, so that a slow client can't hold up the accept loop.
+type proxyProtoConn struct {
+	net.Conn
+
+	r *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyProtoConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyProtoHeaderTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		c.remoteAddr, c.err = parseProxyProtoHeader(c.r)
+		if c.err != nil {
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtoConn) Read(b []byte) (int, error) {
+	c.readHeader()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// ReadFrom lets the wrapped connection send files with sendfile, as net/http
+// only does so when the connection itself implements io.ReaderFrom.
+func (c *proxyProtoConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	c.readHeader()
+
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// parseProxyProtoHeader returns a nil address for UNKNOWN connections.
+func parseProxyProtoHeader(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("proxy protocol header too long")
+		}
+
+		return nil, err
+	}
+
+	header := string(line)
+
+	if !strings.HasPrefix(header, "PROXY ") || !strings.HasSuffix(header, "\r\n") {
+		return nil, fmt.Errorf("invalid proxy protocol header")
+	}
+
+	fields := strings.Fields(header)
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid proxy protocol source address '%s'", fields[2])
+	}
+
+	port, err := strconv.Atoi(fields[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid proxy protocol source port '%s'", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
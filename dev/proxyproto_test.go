@@ -0,0 +1,112 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProxyProtoHeader(t *testing.T) {
+	parse := func(header string) (net.Addr, error) {
+		return parseProxyProtoHeader(bufio.NewReader(strings.NewReader(header)))
+	}
+
+	addr, err := parse("PROXY TCP4 192.0.2.10 198.51.100.1 56324 443\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.10:56324", addr.String())
+
+	addr, err = parse("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:56324", addr.String())
+
+	addr, err = parse("PROXY UNKNOWN\r\n")
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+
+	_, err = parse("GET / HTTP/1.1\r\n")
+	assert.Error(t, err)
+
+	_, err = parse("PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n")
+	assert.Error(t, err)
+
+	_, err = parse("PROXY TCP4 192.0.2.10 198.51.100.1 56324 443\n")
+	assert.Error(t, err)
+
+	_, err = parse("PROXY TCP4 192.0.2.10 198.51.100.1 " + strings.Repeat("1", 200) + "\r\n")
+	assert.Error(t, err)
+}
+
+func TestHttp_proxyProtocol(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-For")))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	serv := &http.Server{Handler: h}
+	go serv.Serve(newProxyProtoListener(l))
+	defer serv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "PROXY TCP4 192.0.2.10 198.51.100.1 56324 80\r\n"+
+		"GET / HTTP/1.1\r\nHost: myapp.test\r\nConnection: close\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	body := make([]byte, 64)
+	n, _ := resp.Body.Read(body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "192.0.2.10", string(body[:n]))
+}
+
+// net/http only uses sendfile for static files when the connection
+// implements io.ReaderFrom, so the PROXY wrapper has to pass it on.
+func TestProxyProtoConn_readFrom(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var conn net.Conn = &proxyProtoConn{Conn: server, r: bufio.NewReader(server)}
+
+	rf, ok := conn.(io.ReaderFrom)
+	if !ok {
+		assert.FailNow(t, "proxyProtoConn doesn't implement io.ReaderFrom")
+	}
+
+	go func() {
+		rf.ReadFrom(strings.NewReader("static file"))
+		server.Close()
+	}()
+
+	data, err := ioutil.ReadAll(client)
+	assert.NoError(t, err)
+	assert.Equal(t, "static file", string(data))
+}
@@ -0,0 +1,31 @@
+package dev
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The size apps see for their terminal.
+const (
+	ptyRows = 24
+	ptyCols = 80
+)
+
+func ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// setPtySize gives the terminal a size, as some tools refuse one without.
+func setPtySize(f *os.File) error {
+	ws := struct {
+		row, col, xpixel, ypixel uint16
+	}{ptyRows, ptyCols, 0, 0}
+
+	return ioctl(f.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
@@ -0,0 +1,46 @@
+package dev
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPty returns a new pseudo-terminal's master and the tty to hand the app.
+func openPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = ioctl(master.Fd(), syscall.TIOCPTYGRANT, 0)
+	if err == nil {
+		err = ioctl(master.Fd(), syscall.TIOCPTYUNLK, 0)
+	}
+
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	name := make([]byte, 128)
+
+	err = ioctl(master.Fd(), syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0])))
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	if i := bytes.IndexByte(name, 0); i != -1 {
+		name = name[:i]
+	}
+
+	tty, err := os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+
+	return master, tty, nil
+}
@@ -0,0 +1,40 @@
+package dev
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttp_tty(t *testing.T) {
+	orig := appCommand
+	defer func() { appCommand = orig }()
+
+	// Report whether stdin and stdout are terminals, then boot like
+	// helperAppCommand.
+	appCommand = func(shell, dir, name, socket string) *exec.Cmd {
+		return exec.Command("/bin/sh", "-c",
+			`if [ -t 0 ] && [ -t 1 ]; then echo "on a tty"; else echo "no tty"; fi; exec "$@"`, "sh",
+			os.Args[0], "-test.run=^TestHelperApp$", "--", "helper-app", socket, "0s", "0")
+	}
+
+	for config, expected := range map[string]string{
+		"":          "no tty\n",
+		"tty: true": "on a tty\n",
+	} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		makeTestApp(t, h, "myapp", config)
+
+		rec := serveTestRequest(h, "GET", "http://myapp.test/")
+		assert.Equal(t, "ok", rec.Body.String(), config)
+
+		rec = serveTestRequest(h, "GET", "http://puma-dev/log/myapp")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), expected, config)
+	}
+}
@@ -0,0 +1,64 @@
+package dev
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows rate requests a second on average, in bursts of up to
+// one second's worth.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(1, math.Ceil(rate))
+
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take uses up a token, or returns how long until the next one if there
+// are none left.
+func (tb *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens = math.Min(tb.burst, tb.tokens+elapsed.Seconds()*tb.rate)
+	}
+
+	tb.last = now
+
+	if tb.tokens >= 1 {
+		tb.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+
+	return false, wait
+}
+
+// rateLimitAdmin answers admin requests beyond AdminRateLimit a second with
+// a 429.
+func (h *HTTPServer) rateLimitAdmin(next http.Handler) http.Handler {
+	bucket := newTokenBucket(h.AdminRateLimit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ok, wait := bucket.take(time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many admin requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, req)
+	})
+}
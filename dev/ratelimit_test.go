@@ -0,0 +1,63 @@
+package dev
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := newTokenBucket(2)
+	now := tb.last
+
+	for i := 0; i < 2; i++ {
+		ok, _ := tb.take(now)
+		assert.True(t, ok)
+	}
+
+	ok, wait := tb.take(now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	ok, _ = tb.take(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+
+	// Idle time doesn't build up past the burst.
+	now = now.Add(time.Hour)
+
+	for i := 0; i < 2; i++ {
+		ok, _ = tb.take(now)
+		assert.True(t, ok)
+	}
+
+	ok, _ = tb.take(now)
+	assert.False(t, ok)
+}
+
+func TestHttp_adminRateLimit(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.AdminRateLimit = 1
+	h.Setup()
+
+	backend := namedBackend("myapp")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/status")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveTestRequest(h, "GET", "http://puma-dev/status")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// Proxied requests don't count.
+	for i := 0; i < 3; i++ {
+		rec = serveTestRequest(h, "GET", "http://myapp.test/")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
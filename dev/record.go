@@ -0,0 +1,220 @@
+package dev
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// recordedExchange is a single request/response pair as stored, one JSON
+// object per line, in a record file.
+type recordedExchange struct {
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	Host            string      `json:"host"`
+	Path            string      `json:"path"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     []byte      `json:"request_body,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBody    []byte      `json:"response_body,omitempty"`
+}
+
+// Stored hashed, so they can still be matched on replay.
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+func redactedValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "redacted-sha256:" + hex.EncodeToString(sum[:])
+}
+
+// redactCredentials returns a copy of header with the values of
+// credentialHeaders replaced by their hashes.
+func redactCredentials(header http.Header) http.Header {
+	header = header.Clone()
+
+	for _, name := range credentialHeaders {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+
+		redacted := make([]string, len(values))
+		for i, v := range values {
+			redacted[i] = redactedValue(v)
+		}
+
+		header[http.CanonicalHeaderKey(name)] = redacted
+	}
+
+	return header
+}
+
+type requestRecorder struct {
+	path string
+	lock sync.Mutex
+}
+
+// record returns the writer to respond through and a function saving the exchange.
+func (r *requestRecorder) record(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, func() error) {
+	ex := &recordedExchange{
+		Method:         req.Method,
+		Host:           req.Host,
+		Path:           req.URL.RequestURI(),
+		RequestHeaders: redactCredentials(req.Header),
+	}
+
+	var reqBody bytes.Buffer
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(req.Body, &reqBody), req.Body}
+	}
+
+	cw := newCaptureWriter(w, true)
+
+	return cw, func() error {
+		ex.Time = time.Now()
+		ex.RequestBody = reqBody.Bytes()
+		ex.Status = cw.Status()
+		ex.ResponseHeaders = cw.Header().Clone()
+		ex.ResponseBody = cw.body.Bytes()
+
+		return r.save(ex)
+	}
+}
+
+func (r *requestRecorder) save(ex *recordedExchange) error {
+	data, err := json.Marshal(ex)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Matching exchanges are replayed in order, repeating the last one.
+type requestReplayer struct {
+	matchHeaders []string
+
+	lock      sync.Mutex
+	exchanges []*recordedExchange
+	served    map[*recordedExchange]bool
+}
+
+func loadReplay(path string, matchHeaders []string) (*requestReplayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	r := &requestReplayer{
+		matchHeaders: matchHeaders,
+		served:       make(map[*recordedExchange]bool),
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var ex recordedExchange
+
+		err = json.Unmarshal(line, &ex)
+		if err != nil {
+			return nil, err
+		}
+
+		r.exchanges = append(r.exchanges, &ex)
+	}
+
+	return r, scanner.Err()
+}
+
+func (r *requestReplayer) matches(ex *recordedExchange, req *http.Request) bool {
+	if ex.Method != req.Method || ex.Host != req.Host || ex.Path != req.URL.RequestURI() {
+		return false
+	}
+
+	header := redactCredentials(req.Header)
+
+	for _, name := range r.matchHeaders {
+		if ex.RequestHeaders.Get(name) != header.Get(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *requestReplayer) find(req *http.Request) *recordedExchange {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var last *recordedExchange
+
+	for _, ex := range r.exchanges {
+		if !r.matches(ex, req) {
+			continue
+		}
+
+		if !r.served[ex] {
+			r.served[ex] = true
+			return ex
+		}
+
+		last = ex
+	}
+
+	return last
+}
+
+// serve writes a recorded response for req to w, returning false if
+// nothing was recorded for it.
+func (r *requestReplayer) serve(w http.ResponseWriter, req *http.Request) bool {
+	ex := r.find(req)
+	if ex == nil {
+		return false
+	}
+
+	if req.Body != nil {
+		io.Copy(ioutil.Discard, req.Body)
+	}
+
+	for k, v := range ex.ResponseHeaders {
+		w.Header()[k] = v
+	}
+
+	w.Header().Set("X-Puma-Dev-Replayed", "true")
+
+	w.WriteHeader(ex.Status)
+	w.Write(ex.ResponseBody)
+
+	return true
+}
@@ -0,0 +1,160 @@
+package dev
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHttp_recordAndReplay(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	hits := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Backend", "live")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %d %s", r.Method, r.URL.RequestURI(), hits, body)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	recordFile := filepath.Join(h.Pool.Dir, "session.jsonl")
+	h.RecordFile = recordFile
+	h.Setup()
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/widgets?page=2")
+	assert.Equal(t, "GET /widgets?page=2 1 ", rec.Body.String())
+
+	req := httptest.NewRequest("POST", "http://myapp.test/widgets", strings.NewReader("name=bolt"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, "POST /widgets 2 name=bolt", rec.Body.String())
+
+	h.RecordFile = ""
+	h.ReplayFile = recordFile
+	h.Setup()
+
+	backend.Close()
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/widgets?page=2")
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "live", rec.Header().Get("X-Backend"))
+	assert.Equal(t, "true", rec.Header().Get("X-Puma-Dev-Replayed"))
+	assert.Equal(t, "GET /widgets?page=2 1 ", rec.Body.String())
+
+	rec = serveTestRequest(h, "POST", "http://myapp.test/widgets")
+	assert.Equal(t, "POST /widgets 2 name=bolt", rec.Body.String())
+
+	assert.Equal(t, 2, hits)
+}
+
+func TestHttp_replay_matchHeaders(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "live %s", r.Header.Get("Accept"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	recordFile := filepath.Join(h.Pool.Dir, "session.jsonl")
+	h.RecordFile = recordFile
+	h.Setup()
+
+	req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Accept", "application/json")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	h.RecordFile = ""
+	h.ReplayFile = recordFile
+	h.ReplayMatchHeaders = []string{"Accept"}
+	h.Setup()
+
+	req = httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, "true", rec.Header().Get("X-Puma-Dev-Replayed"))
+	assert.Equal(t, "live application/json", rec.Body.String())
+
+	// A different Accept header isn't in the recording, so it's proxied.
+	req = httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("X-Puma-Dev-Replayed"))
+	assert.Equal(t, "live text/html", rec.Body.String())
+}
+
+func TestHttp_replay_allowedHosts(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	linkTestProxyApp(t, h, "locked", "http://localhost:1", "allowed_hosts:\n  - locked.test\n")
+
+	recordFile := filepath.Join(h.Pool.Dir, "session.jsonl")
+	ioutil.WriteFile(recordFile, []byte(`{"method":"GET","host":"www.locked.test","path":"/","status":200,"response_body":"cmVwbGF5ZWQ="}`+"\n"), 0644)
+
+	h.ReplayFile = recordFile
+	h.Setup()
+
+	rec := serveTestRequest(h, "GET", "http://www.locked.test/")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-Puma-Dev-Replayed"))
+}
+
+func TestHttp_record_redactsCredentials(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "live %s", r.Header.Get("Cookie"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	recordFile := filepath.Join(h.Pool.Dir, "session.jsonl")
+	h.RecordFile = recordFile
+	h.Setup()
+
+	req := httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Cookie", "session=hunter2")
+	req.Header.Set("Authorization", "Bearer hunter2")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	data, err := ioutil.ReadFile(recordFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+	assert.Contains(t, string(data), "redacted-sha256:")
+
+	h.RecordFile = ""
+	h.ReplayFile = recordFile
+	h.ReplayMatchHeaders = []string{"Cookie"}
+	h.Setup()
+
+	req = httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Cookie", "session=hunter2")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, "true", rec.Header().Get("X-Puma-Dev-Replayed"))
+	assert.Equal(t, "live session=hunter2", rec.Body.String())
+
+	req = httptest.NewRequest("GET", "http://myapp.test/", nil)
+	req.Header.Set("Cookie", "session=other")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get("X-Puma-Dev-Replayed"))
+}
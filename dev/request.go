@@ -0,0 +1,38 @@
+package dev
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// decompressRequest replaces a gzipped request body with its contents, read
+// in full so that a malformed body is caught before the app sees any of it.
+func decompressRequest(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody ||
+		!strings.EqualFold(strings.TrimSpace(req.Header.Get("Content-Encoding")), "gzip") {
+		return nil
+	}
+
+	zr, err := gzip.NewReader(req.Body)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(zr)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.TransferEncoding = nil
+	req.Header.Del("Content-Encoding")
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package dev
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+
+	return buf.Bytes()
+}
+
+func TestHttp_decompressRequests(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%q %d %s", r.Header.Get("Content-Encoding"), r.ContentLength, body)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	post := func(body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://myapp.test/", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	compressed := gzipped(`{"hello":"world"}`)
+
+	rec := post(compressed)
+	assert.True(t, strings.HasPrefix(rec.Body.String(), fmt.Sprintf(`"gzip" %d `, len(compressed))))
+
+	h.DecompressRequests = true
+
+	rec = post(compressed)
+	assert.Equal(t, `"" 17 {"hello":"world"}`, rec.Body.String())
+
+	rec = post([]byte("not gzip"))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = post(compressed[:len(compressed)-4])
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, eventsString(h.Events), `"event":"request_decompress_error","host":"myapp.test","path":"/"`)
+}
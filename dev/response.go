@@ -0,0 +1,440 @@
+package dev
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// How to pass on app responses without a Content-Length or chunking.
+const (
+	UnframedChunk  = "chunk"
+	UnframedClose  = "close"
+	UnframedBuffer = "buffer"
+)
+
+// What clients see when an app closes the connection mid-response.
+const (
+	TruncatedAbort = "abort"
+	TruncatedMark  = "mark"
+)
+
+// How to pass on responses without a Content-Length to HTTP/1.0 clients.
+const (
+	HTTP10Close  = "close"
+	HTTP10Buffer = "buffer"
+)
+
+const truncatedHTMLMarker = `
+<div style="position:fixed;bottom:0;left:0;right:0;padding:1em;background:#c00;color:#fff;font:14px monospace;z-index:2147483647">
+puma-dev: this response is incomplete, the app closed the connection before finishing it
+</div>
+`
+
+// modifyResponse is used as the ModifyResponse hook of the reverse
+// proxies.
+func (h *HTTPServer) modifyResponse(resp *http.Response) error {
+	h.circuitSuccess(resp.Request)
+	h.logResponseHeaders(resp)
+
+	rewriteLocation(resp)
+
+	err := h.serveAccelRedirect(resp)
+	if err != nil {
+		return err
+	}
+
+	err = h.replaceBody(resp)
+	if err != nil {
+		return err
+	}
+
+	h.watchForTruncation(resp)
+
+	err = h.frameUnframedResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	return h.frameForHTTP10(resp)
+}
+
+// truncationReader notices the app's response body ending with an error,
+// which means the app went away in the middle of the response.
+type truncationReader struct {
+	io.ReadCloser
+
+	h      *HTTPServer
+	resp   *http.Response
+	read   int64
+	marker []byte
+}
+
+func (h *HTTPServer) watchForTruncation(resp *http.Response) {
+	// Upgraded connections hand the body over as the raw connection, which
+	// has to stay writable.
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		return
+	}
+
+	resp.Body = &truncationReader{ReadCloser: resp.Body, h: h, resp: resp}
+}
+
+func (r *truncationReader) Read(p []byte) (int, error) {
+	if r.marker != nil {
+		n := copy(p, r.marker)
+		r.marker = r.marker[n:]
+
+		if len(r.marker) == 0 {
+			return n, io.EOF
+		}
+
+		return n, nil
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+
+	name := ""
+	if app, ok := r.resp.Request.Context().Value(appContextKey).(*App); ok {
+		name = app.Name
+	}
+
+	r.h.Events.Add("upstream_truncated",
+		"app", name,
+		"path", r.resp.Request.URL.Path,
+		"bytes", r.read,
+		"error", err.Error(),
+	)
+
+	fmt.Printf("! App '%s' closed the connection mid-response for %s after %d bytes: %s\n",
+		name, r.resp.Request.URL.Path, r.read, err)
+
+	if r.h.TruncatedResponseMode != TruncatedMark || r.resp.ContentLength >= 0 {
+		return n, err
+	}
+
+	if r.resp.Trailer == nil {
+		r.resp.Trailer = make(http.Header)
+	}
+
+	r.resp.Trailer.Set("X-Puma-Dev-Upstream-Error", "response truncated: "+err.Error())
+
+	if ctype, _, _ := mime.ParseMediaType(r.resp.Header.Get("Content-Type")); ctype == "text/html" {
+		r.marker = []byte(truncatedHTMLMarker)
+		return n, nil
+	}
+
+	return n, io.EOF
+}
+
+func isUnframed(resp *http.Response) bool {
+	if resp.ContentLength >= 0 || len(resp.TransferEncoding) > 0 {
+		return false
+	}
+
+	switch {
+	case resp.StatusCode >= 100 && resp.StatusCode <= 199,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified,
+		resp.Request != nil && resp.Request.Method == "HEAD":
+		return false
+	}
+
+	return resp.Close
+}
+
+func (h *HTTPServer) frameUnframedResponse(resp *http.Response) error {
+	if !isUnframed(resp) {
+		return nil
+	}
+
+	switch h.UnframedResponseMode {
+	case UnframedClose:
+		resp.Header.Set("Connection", "close")
+	case UnframedBuffer:
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+
+	return nil
+}
+
+// replaceBody makes the app's body_replacements in resp. Gzipped bodies are
+// passed on decompressed, other encodings as they are.
+func (h *HTTPServer) replaceBody(resp *http.Response) error {
+	app, ok := resp.Request.Context().Value(appContextKey).(*App)
+	if !ok || len(app.Config.BodyReplacements) == 0 {
+		return nil
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody || resp.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+
+	ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	var rules []BodyReplacement
+
+	for _, r := range app.Config.BodyReplacements {
+		if r.appliesTo(ctype) {
+			rules = append(rules, r)
+		}
+	}
+
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+
+	if len(rules) == 0 || (encoding != "" && encoding != "identity" && encoding != "gzip") {
+		return nil
+	}
+
+	var body io.Reader = resp.Body
+
+	if encoding == "gzip" {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+
+		body = zr
+	}
+
+	data, err := ioutil.ReadAll(body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range rules {
+		data = bytes.ReplaceAll(data, []byte(r.Find), []byte(r.Replace))
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("ETag")
+
+	// Trailers only survive a chunked response, so leave those unsized.
+	if len(resp.Trailer) > 0 {
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+
+	resp.ContentLength = int64(len(data))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+
+	return nil
+}
+
+// resolveAppFile maps target onto a file under dir, refusing anything outside.
+// dir is usually a symlink in ~/.puma-dev, while apps send absolute paths
+// under the directory it points to, so either is taken off target.
+func resolveAppFile(dir, target string) (string, bool) {
+	dir = filepath.Clean(dir)
+
+	root := dir
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		root = resolved
+	}
+
+	for _, prefix := range []string{dir, root} {
+		if strings.HasPrefix(target, prefix+string(filepath.Separator)) {
+			target = strings.TrimPrefix(target, prefix)
+			break
+		}
+	}
+
+	full := filepath.Join(root, filepath.FromSlash(path.Clean("/"+target)))
+
+	if !strings.HasPrefix(full, root+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return full, true
+}
+
+// rewriteLocation points redirects to the app's own address, or to the host
+// a request was rewritten to, back at the host the client used.
+func rewriteLocation(resp *http.Response) {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return
+	}
+
+	u, err := url.Parse(loc)
+	if err != nil || u.Host == "" {
+		return
+	}
+
+	req := resp.Request
+
+	// With upstream_host req.Host is the app's, the client's went along in
+	// X-Forwarded-Host.
+	host := req.Host
+	if app, ok := req.Context().Value(appContextKey).(*App); ok && app.Config.UpstreamHost != "" {
+		host = req.Header.Get("X-Forwarded-Host")
+	}
+
+	if u.Host == host || !isInternalLocation(req, u, host) {
+		return
+	}
+
+	u.Scheme = req.Header.Get("X-Forwarded-Proto")
+	if u.Scheme == "" {
+		u.Scheme = "http"
+	}
+
+	u.Host = host
+
+	resp.Header.Set("Location", u.String())
+}
+
+// isInternalLocation reports whether u names the app rather than host, the
+// one the client asked for, e.g. http://localhost:3000 or host on the app's
+// port.
+func isInternalLocation(req *http.Request, u *url.URL, host string) bool {
+	if u.Host == req.URL.Host || u.Host == req.Host {
+		return true
+	}
+
+	// Set when the PCO routing sent the request to another app.
+	if rewritten := req.Header.Get("Host"); rewritten != "" && u.Hostname() == rewritten {
+		return true
+	}
+
+	if u.Port() == "" || u.Port() != req.URL.Port() {
+		return false
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1", host:
+		return true
+	}
+
+	return false
+}
+
+// serveAccelRedirect serves the app file named in X-Accel-Redirect, like nginx.
+func (h *HTTPServer) serveAccelRedirect(resp *http.Response) error {
+	target := resp.Header.Get("X-Accel-Redirect")
+	if target == "" {
+		return nil
+	}
+
+	app, ok := resp.Request.Context().Value(appContextKey).(*App)
+	if !ok || app.dir == "" {
+		return nil
+	}
+
+	resp.Header.Del("X-Accel-Redirect")
+
+	if i := strings.IndexByte(target, '?'); i != -1 {
+		target = target[:i]
+	}
+
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+
+	file, ok := resolveAppFile(app.dir, target)
+	if !ok {
+		app.eventAdd("accel_redirect_blocked", "target", target)
+		replaceResponseBody(resp, http.StatusForbidden, "text/plain; charset=utf-8", "forbidden")
+		return nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		replaceResponseBody(resp, http.StatusNotFound, "text/plain; charset=utf-8", "not found")
+		return nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		f.Close()
+		replaceResponseBody(resp, http.StatusNotFound, "text/plain; charset=utf-8", "not found")
+		return nil
+	}
+
+	resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") == "" {
+		if ctype := mime.TypeByExtension(filepath.Ext(file)); ctype != "" {
+			resp.Header.Set("Content-Type", ctype)
+		}
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.TransferEncoding = nil
+	resp.Body = f
+	resp.ContentLength = fi.Size()
+	resp.Header.Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+
+	return nil
+}
+
+func replaceResponseBody(resp *http.Response, status int, contentType, body string) {
+	resp.Body.Close()
+
+	resp.StatusCode = status
+	resp.Status = strconv.Itoa(status) + " " + http.StatusText(status)
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.TransferEncoding = nil
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(strings.NewReader(body))
+}
+
+// Without buffering net/http closes the connection after such responses.
+func (h *HTTPServer) frameForHTTP10(resp *http.Response) error {
+	if h.HTTP10ResponseMode != HTTP10Buffer || resp.Request == nil || resp.Request.ProtoAtLeast(1, 1) {
+		return nil
+	}
+
+	if resp.ContentLength >= 0 || resp.StatusCode == http.StatusSwitchingProtocols ||
+		resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
@@ -0,0 +1,787 @@
+package dev
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/puma/puma-dev/dev/devtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// unframedBackend answers every request with a body that is delimited only
+// by the connection closing, despite claiming keep-alive.
+func unframedBackend(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				buf := make([]byte, 4096)
+				conn.Read(buf)
+
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nConnection: keep-alive\r\nContent-Type: text/plain\r\n\r\nhello "))
+				conn.Write([]byte("unframed world"))
+			}()
+		}
+	}()
+
+	return l
+}
+
+func getThroughServer(t *testing.T, h *HTTPServer, host string) (*http.Response, string) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/", nil)
+	req.Host = host
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func TestHttp_unframedResponse(t *testing.T) {
+	tests := []struct {
+		mode  string
+		check func(t *testing.T, resp *http.Response)
+	}{
+		{UnframedChunk, func(t *testing.T, resp *http.Response) {
+			assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		}},
+		{UnframedClose, func(t *testing.T, resp *http.Response) {
+			assert.True(t, resp.Close)
+		}},
+		{UnframedBuffer, func(t *testing.T, resp *http.Response) {
+			assert.Equal(t, int64(len("hello unframed world")), resp.ContentLength)
+			assert.False(t, resp.Close)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			h.UnframedResponseMode = tt.mode
+
+			backend := unframedBackend(t)
+			defer backend.Close()
+
+			linkTestProxyApp(t, h, "myapp", "http://"+backend.Addr().String(), "")
+
+			resp, body := getThroughServer(t, h, "myapp.test")
+
+			assert.Equal(t, "hello unframed world", body)
+			tt.check(t, resp)
+		})
+	}
+}
+
+func accelRedirectResponse(t *testing.T, app *App, target string) *http.Response {
+	req := httptest.NewRequest("GET", "http://myapp.test/download", nil)
+	req = req.WithContext(context.WithValue(req.Context(), appContextKey, app))
+
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(strings.NewReader("")),
+		ContentLength: 0,
+		Request:       req,
+	}
+	resp.Header.Set("X-Accel-Redirect", target)
+
+	var h HTTPServer
+	assert.NoError(t, h.modifyResponse(resp))
+
+	return resp
+}
+
+func TestHttp_accelRedirect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "puma-dev-accel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer MakeDirectoryOrFail(t, filepath.Join(dir, "private"))()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "private", "report.csv"), []byte("a,b\n1,2\n"), 0644))
+
+	app := &App{Name: "myapp", dir: dir, Events: &Events{}}
+
+	for _, target := range []string{"/private/report.csv", filepath.Join(dir, "private", "report.csv")} {
+		resp := accelRedirectResponse(t, app, target)
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "a,b\n1,2\n", string(body))
+		assert.Equal(t, int64(8), resp.ContentLength)
+		assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Empty(t, resp.Header.Get("X-Accel-Redirect"))
+	}
+}
+
+func TestHttp_accelRedirect_symlinkedApp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "puma-dev-accel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	checkout := filepath.Join(dir, "code", "myapp")
+	defer MakeDirectoryOrFail(t, filepath.Join(checkout, "storage"))()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(checkout, "storage", "report.csv"), []byte("a,b\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "code", "secret"), []byte("nope"), 0644))
+
+	link := filepath.Join(dir, "myapp")
+	assert.NoError(t, os.Symlink(checkout, link))
+
+	app := &App{Name: "myapp", dir: link, Events: &Events{}}
+
+	for _, target := range []string{
+		"/storage/report.csv",
+		filepath.Join(link, "storage", "report.csv"),
+		filepath.Join(checkout, "storage", "report.csv"),
+	} {
+		resp := accelRedirectResponse(t, app, target)
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode, target)
+		assert.Equal(t, "a,b\n", string(body), target)
+	}
+
+	for _, target := range []string{checkout + "/../secret", "/../secret"} {
+		resp := accelRedirectResponse(t, app, target)
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.NotEqual(t, http.StatusOK, resp.StatusCode, target)
+		assert.NotEqual(t, "nope", string(body), target)
+	}
+}
+
+func TestHttp_accelRedirect_traversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "puma-dev-accel")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("nope"), 0644))
+
+	app := &App{Name: "myapp", dir: filepath.Join(dir, "app"), Events: &Events{}}
+	defer MakeDirectoryOrFail(t, app.dir)()
+
+	resp := accelRedirectResponse(t, app, "/../secret")
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.NotEqual(t, "nope", string(body))
+
+	resp = accelRedirectResponse(t, app, "/missing.txt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHttp_accelRedirect_proxyAppUntouched(t *testing.T) {
+	resp := accelRedirectResponse(t, &App{Name: "proxy"}, "/private/report.csv")
+
+	assert.Equal(t, "/private/report.csv", resp.Header.Get("X-Accel-Redirect"))
+}
+
+// truncatingBackend sends the start of a chunked response of the given
+// content type and then drops the connection.
+func truncatingBackend(t *testing.T, contentType string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				buf := make([]byte, 4096)
+				conn.Read(buf)
+
+				conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: " + contentType + "\r\nTransfer-Encoding: chunked\r\n\r\n"))
+				conn.Write([]byte("6\r\nhello \r\n"))
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestHttp_truncatedResponse_abort(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := truncatingBackend(t, "text/html")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", "http://"+backend.Addr().String(), "")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/page", nil)
+	req.Host = "myapp.test"
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	_, err = ioutil.ReadAll(resp.Body)
+	assert.Error(t, err)
+
+	assert.Contains(t, eventsString(h.Events), `"event":"upstream_truncated","app":"myapp","path":"/page","bytes":6`)
+}
+
+func TestHttp_truncatedResponse_mark(t *testing.T) {
+	tests := []struct {
+		contentType string
+		banner      bool
+	}{
+		{"text/html; charset=utf-8", true},
+		{"application/json", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			h.TruncatedResponseMode = TruncatedMark
+
+			backend := truncatingBackend(t, tt.contentType)
+			defer backend.Close()
+
+			linkTestProxyApp(t, h, "myapp", "http://"+backend.Addr().String(), "")
+
+			resp, body := getThroughServer(t, h, "myapp.test")
+
+			if tt.banner {
+				assert.True(t, strings.HasPrefix(body, "hello "))
+				assert.Contains(t, body, "puma-dev: this response is incomplete")
+			} else {
+				assert.Equal(t, "hello ", body)
+			}
+
+			assert.Contains(t, resp.Trailer.Get("X-Puma-Dev-Upstream-Error"), "response truncated")
+			assert.Contains(t, eventsString(h.Events), `"event":"upstream_truncated"`)
+		})
+	}
+}
+
+// getHTTP10 makes an HTTP/1.0 keep-alive request through h for host.
+func getHTTP10(t *testing.T, h *HTTPServer, host string) (*http.Response, string) {
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer c.Close()
+
+	fmt.Fprintf(c, "GET / HTTP/1.0\r\nHost: %s\r\nConnection: keep-alive\r\n\r\n", host)
+
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	return resp, string(body)
+}
+
+func chunkedBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("chunked world"))
+	}))
+}
+
+func TestHttp_http10Response_close(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := chunkedBackend()
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	resp, body := getHTTP10(t, h, "myapp.test")
+
+	assert.Equal(t, "hello chunked world", body)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.True(t, resp.Close)
+}
+
+func TestHttp_http10Response_buffer(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.HTTP10ResponseMode = HTTP10Buffer
+
+	backend := chunkedBackend()
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	resp, body := getHTTP10(t, h, "myapp.test")
+
+	assert.Equal(t, "hello chunked world", body)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.Equal(t, int64(len("hello chunked world")), resp.ContentLength)
+	assert.False(t, resp.Close)
+
+	resp, body = getThroughServer(t, h, "myapp.test")
+
+	assert.Equal(t, "hello chunked world", body)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+}
+
+// rawBackend answers every request with response, sending the raw request
+// it got on requests.
+func rawBackend(t *testing.T, response string, requests chan<- string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+
+				var raw strings.Builder
+
+				for {
+					line, err := r.ReadString('\n')
+					raw.WriteString(line)
+
+					if err != nil || line == "\r\n" {
+						break
+					}
+				}
+
+				requests <- raw.String()
+
+				conn.Write([]byte(response))
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestHttp_preserveHeaderCase(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	requests := make(chan string, 1)
+
+	backend := rawBackend(t, "HTTP/1.1 200 OK\r\nx-legacy-token: abc\r\nX-OTHER-thing: 1\r\nContent-Length: 2\r\n\r\nok", requests)
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "legacy", "http://"+backend.Addr().String(), "preserve_header_case:\n  - x-API-key\n  - x-legacy-token\n")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer c.Close()
+
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: legacy.test\r\nX-Api-Key: secret\r\nX-Other-Header: 2\r\nConnection: close\r\n\r\n")
+
+	response, err := ioutil.ReadAll(c)
+	assert.NoError(t, err)
+
+	upstream := <-requests
+
+	assert.Contains(t, upstream, "\r\nx-API-key: secret\r\n")
+	assert.Contains(t, upstream, "\r\nX-Other-Header: 2\r\n")
+
+	assert.Contains(t, string(response), "\r\nx-legacy-token: abc\r\n")
+	assert.Contains(t, string(response), "\r\nX-Other-Thing: 1\r\n")
+	assert.True(t, strings.HasSuffix(string(response), "\r\n\r\nok"))
+}
+
+func TestHttp_streamThreshold(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.StreamThreshold = 64 << 10
+
+	const (
+		promptSize = 64<<10 + 10
+		largeSize  = 64 << 20
+	)
+
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, 32<<10)
+
+		if r.URL.Path == "/prompt" {
+			w.Header().Set("Content-Length", strconv.Itoa(2*promptSize))
+			w.Write(make([]byte, promptSize-10))
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+			w.Write(make([]byte, 10))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write(make([]byte, promptSize))
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(largeSize))
+		for i := 0; i < largeSize/len(chunk); i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	linkTestProxyApp(t, h, "download", backend.URL, "")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Host = "download.test"
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		return resp
+	}
+
+	t.Run("streams promptly", func(t *testing.T) {
+		resp := get("/prompt")
+		defer resp.Body.Close()
+
+		read := make(chan error, 1)
+		go func() {
+			_, err := io.ReadFull(resp.Body, make([]byte, promptSize))
+			read <- err
+		}()
+
+		select {
+		case err := <-read:
+			assert.NoError(t, err)
+		case <-time.After(500 * time.Millisecond):
+			assert.Fail(t, "the start of the response was held back")
+		}
+	})
+
+	t.Run("bounded memory", func(t *testing.T) {
+		var before, during runtime.MemStats
+
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		resp := get("/large")
+		defer resp.Body.Close()
+
+		_, err := io.CopyN(ioutil.Discard, resp.Body, largeSize/2)
+		assert.NoError(t, err)
+
+		runtime.GC()
+		runtime.ReadMemStats(&during)
+
+		assert.Less(t, int64(during.HeapAlloc)-int64(before.HeapAlloc), int64(8<<20))
+
+		n, err := io.Copy(ioutil.Discard, resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(largeSize/2), n)
+	})
+}
+
+func TestHttp_bodyReplacements(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	const page = `<script src="https://cdn.example.com/app.js"></script>`
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gzip":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			zw.Write([]byte(page))
+			zw.Close()
+		case "/br":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(page))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(page))
+		case "/css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte(`@import "https://cdn.example.com/app.css";`))
+		default:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(page))
+		}
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, `
+body_replacements:
+  - find: https://cdn.example.com
+    replace: http://assets.test
+  - find: "@import"
+    replace: "@import url"
+    content_types: [text/css]
+`)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	tests := []struct {
+		path     string
+		encoding string
+		body     string
+	}{
+		{"/", "", `<script src="http://assets.test/app.js"></script>`},
+		{"/gzip", "", `<script src="http://assets.test/app.js"></script>`},
+		{"/br", "br", page},
+		{"/image", "", page},
+		{"/css", "", `@import url "https://cdn.example.com/app.css";`},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", srv.URL+tt.path, nil)
+		req.Host = "myapp.test"
+		req.Header.Set("Accept-Encoding", "gzip, br")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		assert.NoError(t, err)
+		assert.Equal(t, tt.encoding, resp.Header.Get("Content-Encoding"), tt.path)
+		assert.Equal(t, tt.body, string(body), tt.path)
+		assert.Equal(t, int64(len(body)), resp.ContentLength, tt.path)
+	}
+}
+
+func trailerBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte("hello "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("world"))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+	}))
+}
+
+func TestHttp_trailers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		setup  func(h *HTTPServer)
+		body   string
+	}{
+		{"plain", "", nil, "hello world"},
+		{"stream threshold", "", func(h *HTTPServer) { h.StreamThreshold = 1 }, "hello world"},
+		{"request log", "", func(h *HTTPServer) { h.JSONLogging = true; h.logOutput = ioutil.Discard }, "hello world"},
+		{"header case", "preserve_header_case:\n  - grpc-status\n", nil, "hello world"},
+		{"body replacements", "body_replacements:\n  - find: world\n    replace: there\n", nil, "hello there"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+
+			backend := trailerBackend()
+			defer backend.Close()
+
+			linkTestProxyApp(t, h, "myapp", backend.URL, tt.config)
+
+			resp, body := getThroughServer(t, h, "myapp.test")
+
+			assert.Equal(t, tt.body, body)
+			assert.Equal(t, "0", resp.Trailer.Get("Grpc-Status"))
+			assert.Equal(t, "done", resp.Trailer.Get("Grpc-Message"))
+		})
+	}
+}
+
+func TestHttp_flushStreamingOnly(t *testing.T) {
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+			<-release
+			w.Write([]byte("data: 2\n\n"))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hello":`))
+		w.(http.Flusher).Flush()
+		w.Write([]byte(`"world"}`))
+	}))
+	defer backend.Close()
+
+	for _, flushStreamingOnly := range []bool{false, true} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		h.FlushStreamingOnly = flushStreamingOnly
+
+		linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+		resp, body := getThroughServer(t, h, "myapp.test")
+		assert.Equal(t, `{"hello":"world"}`, body)
+
+		if flushStreamingOnly {
+			assert.Equal(t, int64(len(body)), resp.ContentLength)
+		} else {
+			assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		}
+	}
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.FlushStreamingOnly = true
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+	req.Host = "myapp.test"
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	first := make([]byte, len("data: 1\n\n"))
+	_, err = io.ReadFull(resp.Body, first)
+	assert.NoError(t, err)
+	assert.Equal(t, "data: 1\n\n", string(first))
+
+	close(release)
+
+	rest, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "data: 2\n\n", string(rest))
+}
+
+func TestHttp_rewriteLocation(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "")
+
+	port := backend.Listener.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		url, to, expected string
+	}{
+		{"http://myapp.test/", backend.URL + "/login", "http://myapp.test/login"},
+		{"http://myapp.test/", fmt.Sprintf("http://localhost:%d/login?next=%%2F", port), "http://myapp.test/login?next=%2F"},
+		{"http://myapp.test/", fmt.Sprintf("http://myapp.test:%d/login", port), "http://myapp.test/login"},
+		{"http://myapp.test:8080/", fmt.Sprintf("http://myapp.test:%d/login", port), "http://myapp.test:8080/login"},
+		{"http://api.pco.test/services/v2/plans", "http://services.pco.test/services/v2/plans/1", "http://api.pco.test/services/v2/plans/1"},
+		{"http://myapp.test/", "https://github.com/login", "https://github.com/login"},
+		{"http://myapp.test/", "http://myapp.test/login", "http://myapp.test/login"},
+		{"http://myapp.test/", "/login", "/login"},
+	}
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, "GET", tt.url+"?to="+url.QueryEscape(tt.to))
+
+		assert.Equal(t, http.StatusFound, rec.Code, tt.to)
+		assert.Equal(t, tt.expected, rec.Header().Get("Location"), tt.to)
+	}
+}
@@ -0,0 +1,56 @@
+package dev
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// retryTransport sends idempotent requests a second time when the app's
+// connection was reset under them, as happens when a keepalive connection
+// is reused just as AppClosed closes it.
+type retryTransport struct {
+	h    *HTTPServer
+	next http.RoundTripper
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	if err == nil || !isConnReset(err) || !canRetry(req) {
+		return resp, err
+	}
+
+	if req.GetBody != nil {
+		body, gerr := req.GetBody()
+		if gerr != nil {
+			return resp, err
+		}
+
+		req.Body = body
+	}
+
+	rt.h.Events.Add("conn_reset_retry",
+		"method", req.Method, "host", req.Host, "path", req.URL.Path, "error", err.Error())
+
+	return rt.next.RoundTrip(req)
+}
+
+// isConnReset reports whether err is the app resetting the connection, or
+// the transport finding it closed while idle. A bare EOF isn't, it can be
+// an app that crashed after acting on the request.
+func isConnReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || strings.Contains(err.Error(), "server closed idle connection")
+}
+
+// canRetry reports whether req may be sent again, which needs an
+// idempotent method and a body that can be read a second time.
+func canRetry(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "PUT", "DELETE":
+	default:
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
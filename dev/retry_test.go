@@ -0,0 +1,93 @@
+package dev
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// resetBackend drops the connection of the first request it gets, with a
+// reset unless reset is false, and answers the rest.
+func resetBackend(reset bool) (*httptest.Server, *int32) {
+	var hits int32
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			if reset {
+				conn.(*net.TCPConn).SetLinger(0)
+			}
+
+			conn.Close()
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+
+	return backend, &hits
+}
+
+func TestHttp_retryOnConnReset(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.RetryOnConnReset = true
+	h.Setup()
+
+	backend, hits := resetBackend(true)
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/page")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(hits))
+	assert.Contains(t, eventsString(h.Events), `"event":"conn_reset_retry","method":"GET","host":"myapp.test","path":"/page"`)
+
+	atomic.StoreInt32(hits, 0)
+
+	rec = serveTestRequest(h, "POST", "http://myapp.test/page")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(hits), "POST")
+	assert.Equal(t, 1, strings.Count(eventsString(h.Events), "conn_reset_retry"))
+}
+
+func TestHttp_retryOnConnReset_disabled(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend, hits := resetBackend(true)
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/page")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(hits))
+	assert.NotContains(t, eventsString(h.Events), "conn_reset_retry")
+}
+
+func TestHttp_retryOnConnReset_notOnEOF(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.RetryOnConnReset = true
+	h.Setup()
+
+	backend, hits := resetBackend(false)
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	rec := serveTestRequest(h, "PUT", "http://myapp.test/page")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(hits))
+	assert.NotContains(t, eventsString(h.Events), "conn_reset_retry")
+}
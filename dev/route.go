@@ -0,0 +1,183 @@
+package dev
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	v2Pattern       = regexp.MustCompile(`^/([\w-]+)/v2`)
+	ccAppPattern    = regexp.MustCompile(`^\/(giving|groups|people|publishing|registrations)`)
+	paramsPattern   = regexp.MustCompile(`\?(.*)$|$`)
+	squigglyPattern = regexp.MustCompile(`^\/~(api|ccapi)\/([\w-]+)`)
+)
+
+// route is where a request for a host and path ends up after the PCO and
+// Church Center rewrites, unless DisablePCORouting, and Aliases. Host and
+// EngineHost are empty when those headers are left alone.
+type route struct {
+	App        string `json:"app"`
+	Host       string `json:"host,omitempty"`
+	EngineHost string `json:"engine_host,omitempty"`
+	Path       string `json:"path"`
+}
+
+func (h *HTTPServer) route(reqHost, path string) route {
+	h.domainsLock.RLock()
+	defer h.domainsLock.RUnlock()
+
+	r := route{App: h.removeTLD(reqHost), Path: path}
+
+	if !h.DisablePCORouting {
+		h.routePCO(&r, strings.Split(reqHost, ":")[0])
+	}
+
+	if alias, ok := h.Aliases[r.App]; ok {
+		r.App = alias
+	}
+
+	return r
+}
+
+// isAdminHost reports whether host is AdminHost, e.g. puma-dev, puma-dev:80
+// or puma-dev.test.
+func (h *HTTPServer) isAdminHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	if strings.EqualFold(host, h.AdminHost) {
+		return true
+	}
+
+	h.domainsLock.RLock()
+	defer h.domainsLock.RUnlock()
+
+	for _, domain := range h.Domains {
+		if strings.EqualFold(host, h.AdminHost+"."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routePCO applies the api.pco, Church Center and ~api rewrites to r.
+func (h *HTTPServer) routePCO(r *route, host string) {
+	path := r.Path
+
+	// Check for API requests.
+	apiMatch := h.apiPattern.FindStringSubmatch(host)
+	if apiMatch != nil {
+		// Both api.pco.test and api.churchcenter.test go to the API app by default,
+		// but we need to check the path to be sure.
+		v2Match := v2Pattern.FindStringSubmatch(path)
+		if v2Match != nil && v2Match[1] != "global" {
+			// The path indicates a different app, e.g. /services/v2/
+			// ...so we'll proxy to that app instead.
+			r.App = fmt.Sprintf("%s.pco", v2Match[1])
+			// We have to change the host header to match the app to which we're sending the request.
+			r.Host = fmt.Sprintf("%s.pco.%s", v2Match[1], apiMatch[2])
+			r.EngineHost = host
+		} else {
+			// This is a plain request to the API app.
+			r.App = "api.pco"
+		}
+	}
+
+	// Check for Church Center requests.
+	ccSubdomainMatch := h.ccPattern.FindStringSubmatch(host)
+	if ccSubdomainMatch != nil && ccSubdomainMatch[1] != "api" {
+		ccPathMatch := ccAppPattern.FindStringSubmatch(r.Path)
+		if ccPathMatch != nil {
+			// This is a request for a specific Church Center app.
+			r.App = fmt.Sprintf("%s.pco", ccPathMatch[1])
+			// We have to change the host header to match the app to which we're sending the request.
+			r.Host = fmt.Sprintf("%s.pco.%s", ccPathMatch[1], ccSubdomainMatch[2])
+			// The path needs to be rewritten to include the subdomain and directory
+			// so the app knows from whence this request actually came.
+			r.Path = ccAppPattern.ReplaceAllString(r.Path, "/church_center")
+			// This matches `?foo=bar...` and captures the `foo=bar` part.
+			r.Path = paramsPattern.ReplaceAllString(r.Path,
+				fmt.Sprintf("?church_center_directory=%s&church_center_subdomain=%s&$1", ccPathMatch[1], ccSubdomainMatch[1]),
+			)
+		} else {
+			// This is a plain request to the Church Center app itself.
+			r.App = "churchcenter"
+		}
+	}
+
+	// Check to see if the path starts with ~api or ~ccapi.
+	squigglyMatch := squigglyPattern.FindStringSubmatch(r.Path)
+	if squigglyMatch != nil {
+		// Ahhh, this is a same-domain request in disguise! We need to proxy this
+		// to a different app than the hostname indicates.
+		r.App = fmt.Sprintf("%s.pco", squigglyMatch[2])
+		r.Host = fmt.Sprintf("%s.pco.%s", squigglyMatch[2], h.domainOf(host))
+		r.EngineHost = host
+	}
+}
+
+// domainOf returns which of Domains host is under, for rewrites to stay on
+// it. Without a match, as when Domains is empty, it's host's last label.
+func (h *HTTPServer) domainOf(host string) string {
+	for _, domain := range h.Domains {
+		if strings.HasSuffix(host, "."+domain) {
+			return domain
+		}
+	}
+
+	return host[strings.LastIndexByte(host, '.')+1:]
+}
+
+// DebugRoutingParam names the app a request goes to with DebugRouting on.
+const DebugRoutingParam = "__puma_app"
+
+// debugRoute sends req to the app named by its DebugRoutingParam when
+// DebugRouting is on, taking the parameter out of the query.
+func (h *HTTPServer) debugRoute(req *http.Request) (route, bool) {
+	if !h.DebugRouting {
+		return route{}, false
+	}
+
+	name := req.URL.Query().Get(DebugRoutingParam)
+	if name == "" {
+		return route{}, false
+	}
+
+	var kept []string
+
+	for _, param := range strings.Split(req.URL.RawQuery, "&") {
+		key, err := url.QueryUnescape(strings.SplitN(param, "=", 2)[0])
+		if err != nil || key != DebugRoutingParam {
+			kept = append(kept, param)
+		}
+	}
+
+	req.URL.RawQuery = strings.Join(kept, "&")
+
+	h.Events.Add("debug_route", "host", req.Host, "path", req.URL.Path, "app", name)
+
+	return route{App: name, Path: req.URL.Path}, true
+}
+
+// routeInfo shows where a request would be sent, without sending it.
+func (h *HTTPServer) routeInfo(w http.ResponseWriter, req *http.Request) {
+	host := req.URL.Query().Get("host")
+	if host == "" {
+		http.Error(w, "host is required", http.StatusBadRequest)
+		return
+	}
+
+	path := req.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	json.NewEncoder(w).Encode(h.route(host, path))
+}
@@ -0,0 +1,248 @@
+package dev
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		host, path string
+		expected   route
+	}{
+		{"myapp.test", "/users", route{App: "myapp", Path: "/users"}},
+		{"myapp.test:8080", "/", route{App: "myapp", Path: "/"}},
+		{"api.pco.test", "/global/v2/me", route{App: "api.pco", Path: "/global/v2/me"}},
+		{"api.pco.test", "/services/v2/plans", route{
+			App: "services.pco", Host: "services.pco.test", EngineHost: "api.pco.test", Path: "/services/v2/plans",
+		}},
+		{"demo.churchcenter.test", "/", route{App: "churchcenter", Path: "/"}},
+		{"demo.churchcenter.test", "/giving/funds", route{
+			App:  "giving.pco",
+			Host: "giving.pco.test",
+			Path: "/church_center/funds?church_center_directory=giving&church_center_subdomain=demo&",
+		}},
+		{"people.pco.test", "/~api/services/v2/plans", route{
+			App: "services.pco", Host: "services.pco.test", EngineHost: "people.pco.test", Path: "/~api/services/v2/plans",
+		}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, h.route(tt.host, tt.path), tt.host+tt.path)
+	}
+}
+
+func TestRoute_disablePCORouting(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.DisablePCORouting = true
+
+	for host, app := range map[string]string{
+		"api.pco.test":           "api.pco",
+		"demo.churchcenter.test": "demo.churchcenter",
+		"people.pco.test":        "people.pco",
+	} {
+		for _, path := range []string{"/services/v2/plans", "/giving/funds", "/~api/services/v2/plans"} {
+			assert.Equal(t, route{App: app, Path: path}, h.route(host, path), host+path)
+		}
+	}
+}
+
+func TestRoute_aliases(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.Aliases = map[string]string{
+		"billing":      "payments-service",
+		"services.pco": "services",
+	}
+
+	backend := namedBackend("payments-service")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "payments-service", backend.URL, "")
+
+	assert.Equal(t, "payments-service", h.route("billing.test", "/").App)
+	assert.Equal(t, "services", h.route("api.pco.test", "/services/v2/plans").App)
+	assert.Equal(t, "other", h.route("other.test", "/").App)
+
+	rec := serveTestRequest(h, "GET", "http://billing.test/")
+	assert.Equal(t, "payments-service billing.test ", rec.Body.String())
+}
+
+func TestRoute_reloadDomains(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for i := 0; i < 100; i++ {
+			h.route("api.pco.test", "/services/v2/plans")
+		}
+	}()
+
+	h.ReloadDomains([]string{"localhost", "co.test"})
+	wg.Wait()
+
+	assert.Equal(t, []string{"co.test", "localhost"}, h.Domains)
+	assert.Equal(t, "myapp", h.route("myapp.co.test", "/").App)
+	assert.Equal(t, "services.pco", h.route("api.pco.localhost", "/services/v2/plans").App)
+	assert.Equal(t, "api.pco", h.route("api.pco.test", "/services/v2/plans").App)
+}
+
+func TestRoute_pcoRewritesKeepDomain(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.ReloadDomains([]string{"test", "localhost", "dev.example"})
+
+	tests := []struct {
+		host, path string
+		expected   route
+	}{
+		{"api.pco.localhost", "/services/v2/plans", route{
+			App: "services.pco", Host: "services.pco.localhost", EngineHost: "api.pco.localhost", Path: "/services/v2/plans",
+		}},
+		{"demo.churchcenter.dev.example", "/giving", route{
+			App:  "giving.pco",
+			Host: "giving.pco.dev.example",
+			Path: "/church_center?church_center_directory=giving&church_center_subdomain=demo&",
+		}},
+		{"people.pco.localhost:8080", "/~api/services/v2/plans", route{
+			App: "services.pco", Host: "services.pco.localhost", EngineHost: "people.pco.localhost", Path: "/~api/services/v2/plans",
+		}},
+		{"api.pco.test", "/services/v2/plans", route{
+			App: "services.pco", Host: "services.pco.test", EngineHost: "api.pco.test", Path: "/services/v2/plans",
+		}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, h.route(tt.host, tt.path), tt.host+tt.path)
+	}
+}
+
+func TestHttp_debugRouting(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "services.pco", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://anything.test/plans?__puma_app=services.pco")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code, "DebugRouting is off")
+
+	h.DebugRouting = true
+
+	rec = serveTestRequest(h, "GET", "http://anything.test/plans?a=1&__puma_app=services.pco&b=2")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "anything.test /plans?a=1&b=2", rec.Body.String())
+	assert.Contains(t, eventsString(h.Events),
+		`"event":"debug_route","host":"anything.test","path":"/plans","app":"services.pco"`)
+
+	rec = serveTestRequest(h, "GET", "http://api.pco.test/people/v2?__puma_app=services.pco")
+	assert.Equal(t, "api.pco.test /people/v2", rec.Body.String())
+}
+
+// targetTransport answers every request with the transport's name and
+// where the request would have gone.
+type targetTransport string
+
+func (tt targetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(string(tt) + " " + req.URL.Host + req.URL.Path)),
+		Request:    req,
+	}, nil
+}
+
+func TestHttp_injectedTransports(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.UnixTransport = targetTransport("unix")
+	h.TCPTransport = targetTransport("tcp")
+	h.Setup()
+
+	linkTestProxyApp(t, h, "myapp", "httpu:///tmp/myapp.sock", "")
+	linkTestProxyApp(t, h, "api.pco", "http://127.0.0.1:4001", "")
+	linkTestProxyApp(t, h, "services.pco", "http://127.0.0.1:4002", "")
+
+	tests := []struct {
+		url, expected string
+	}{
+		{"http://myapp.test/users", "unix /tmp/myapp.sock/users"},
+		{"http://api.pco.test/global/v2/me", "tcp 127.0.0.1:4001/global/v2/me"},
+		{"http://api.pco.test/services/v2/plans", "tcp 127.0.0.1:4002/services/v2/plans"},
+		{"http://people.pco.test/~api/services/v2/plans", "tcp 127.0.0.1:4002/~api/services/v2/plans"},
+	}
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, "GET", tt.url)
+		assert.Equal(t, tt.expected, rec.Body.String(), tt.url)
+	}
+}
+
+func TestHttp_routeInfo(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	rec := serveTestRequest(h, "GET", "http://puma-dev/route?host=api.pco.test&path=/services/v2/plans")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var r route
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &r))
+	assert.Equal(t, h.route("api.pco.test", "/services/v2/plans"), r)
+	assert.Equal(t, "services.pco", r.App)
+
+	// Nothing is proxied, so no app gets booted.
+	assert.Empty(t, h.Pool.apps)
+
+	rec = serveTestRequest(h, "GET", "http://puma-dev/route")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+// Compiling even one of the routing regexes per request costs more than this.
+func TestRoute_allocs(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		h.route("api.pco.test", "/services/v2/plans?per_page=10")
+	})
+
+	assert.Less(t, allocs, float64(20))
+}
+
+func BenchmarkRoute(b *testing.B) {
+	h, cleanup := newTestHTTPServer(b)
+	defer cleanup()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		h.route("api.pco.test", "/services/v2/plans?per_page=10")
+		h.route("giving.churchcenter.test", "/giving")
+		h.route("myapp.test", "/")
+	}
+}
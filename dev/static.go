@@ -0,0 +1,280 @@
+package dev
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vektra/errors"
+)
+
+// precompressedEncodings are the pre-compressed siblings looked for next to
+// static files, in order of preference.
+var precompressedEncodings = []struct {
+	encoding string
+	ext      string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// publicPath maps urlPath onto the app's public directory. The path is
+// cleaned first so it can't reach outside of it.
+func publicPath(app *App, urlPath string) string {
+	return filepath.Join(app.dir, app.Config.publicDir(), path.Clean(urlPath))
+}
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// serveACMEChallenge answers ACME http-01 challenges for any host from
+// WellKnownDir, reporting whether it did.
+func (h *HTTPServer) serveACMEChallenge(w http.ResponseWriter, req *http.Request) bool {
+	if h.WellKnownDir == "" || !strings.HasPrefix(req.URL.Path, acmeChallengePrefix) {
+		return false
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	token := path.Clean("/" + strings.TrimPrefix(req.URL.Path, acmeChallengePrefix))
+
+	f, err := os.Open(filepath.Join(h.WellKnownDir, "acme-challenge", filepath.FromSlash(token)))
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+
+	h.Events.Add("acme_challenge_served", "host", req.Host, "path", req.URL.Path)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeContent(w, req, token, fi.ModTime(), f)
+
+	return true
+}
+
+// serveGlobalStatic serves files in GlobalStaticDir to apps puma-dev boots
+// that lack them, sparing the app the request. Proxy and upstream apps may
+// serve anything themselves, so they're left alone.
+func (h *HTTPServer) serveGlobalStatic(w http.ResponseWriter, req *http.Request, app *App) bool {
+	if h.GlobalStaticDir == "" || app.dir == "" || app.Config.upstream != nil || !h.shouldServeStaticPath(req) {
+		return false
+	}
+
+	if _, err := os.Stat(publicPath(app, req.URL.Path)); err == nil {
+		return false
+	}
+
+	file := filepath.Join(h.GlobalStaticDir, filepath.FromSlash(path.Clean(req.URL.Path)))
+
+	fi, err := os.Stat(file)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+
+	return h.serveStaticFile(w, req, file, fi)
+}
+
+// hasPathTraversal reports whether the decoded urlPath has a .. segment,
+// counting backslashes as separators too.
+func hasPathTraversal(urlPath string) bool {
+	for _, segment := range strings.FieldsFunc(urlPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serveMaintenancePage answers with the app's public/maintenance.html and a
+// 503 when the app couldn't be started, reporting whether it had one.
+func serveMaintenancePage(w http.ResponseWriter, app *App, bootErr error) bool {
+	if app.dir == "" {
+		return false
+	}
+
+	f, err := os.Open(publicPath(app, "/maintenance.html"))
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+
+	app.eventAdd("maintenance_page_served", "error", bootErr.Error())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	io.Copy(w, f)
+
+	return true
+}
+
+// serveStaticFile serves the file at path, preferring a pre-compressed
+// sibling (e.g. app.js.br) when the client accepts its encoding.
+func (h *HTTPServer) serveStaticFile(w http.ResponseWriter, req *http.Request, path string, fi os.FileInfo) bool {
+	for _, pc := range precompressedEncodings {
+		cfi, err := os.Stat(path + pc.ext)
+		if err != nil || cfi.IsDir() {
+			continue
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if !acceptsEncoding(req, pc.encoding) {
+			continue
+		}
+
+		ctype := mime.TypeByExtension(filepath.Ext(path))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+
+		var content io.ReadSeeker = &headContent{size: cfi.Size()}
+
+		if req.Method != "HEAD" {
+			f, err := os.Open(path + pc.ext)
+			if err != nil {
+				continue
+			}
+
+			defer f.Close()
+
+			content = h.staticContent(f)
+		}
+
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Content-Encoding", pc.encoding)
+		w.Header().Set("ETag", staticETag(cfi, pc.encoding))
+
+		http.ServeContent(w, req, req.URL.Path, cfi.ModTime(), content)
+		return true
+	}
+
+	// HEAD requests only need the file's size, unless its type has to be
+	// sniffed from the content.
+	if ctype := mime.TypeByExtension(filepath.Ext(path)); req.Method == "HEAD" && ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("ETag", staticETag(fi, ""))
+
+		http.ServeContent(w, req, req.URL.Path, fi.ModTime(), &headContent{size: fi.Size()})
+		return true
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+
+	w.Header().Set("ETag", staticETag(fi, ""))
+
+	// Named after the file rather than the URL, which for / has no extension
+	// to take the type from.
+	http.ServeContent(w, req, path, fi.ModTime(), h.staticContent(f))
+	return true
+}
+
+// headContent stands in for a file's content when only its size is needed.
+type headContent struct {
+	size   int64
+	offset int64
+}
+
+func (c *headContent) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *headContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.size
+	}
+
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+
+	c.offset = offset
+
+	return offset, nil
+}
+
+// staticETag is built from mtime and size like nginx's, plus the encoding.
+func staticETag(fi os.FileInfo, encoding string) string {
+	tag := fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size())
+
+	if encoding != "" {
+		tag += "-" + encoding
+	}
+
+	return `"` + tag + `"`
+}
+
+// Passing the *os.File itself lets net/http use sendfile.
+func (h *HTTPServer) staticContent(f *os.File) io.ReadSeeker {
+	if h.DisableSendfile {
+		return struct{ io.ReadSeeker }{f}
+	}
+
+	return f
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding header
+// allows encoding, honoring q=0 exclusions.
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	for _, header := range req.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+
+			name := strings.TrimSpace(fields[0])
+			if !strings.EqualFold(name, encoding) && name != "*" {
+				continue
+			}
+
+			q := 1.0
+
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = v
+					}
+				}
+			}
+
+			if q > 0 {
+				return true
+			}
+
+			if name != "*" {
+				return false
+			}
+		}
+	}
+
+	return false
+}
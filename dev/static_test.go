@@ -0,0 +1,645 @@
+package dev
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestPublicApp(t *testing.T, h *HTTPServer, files map[string]string) {
+	makeTestApp(t, h, "static", "")
+
+	public := filepath.Join(h.Pool.Dir, "static", "public")
+
+	for name, content := range files {
+		path := filepath.Join(public, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			assert.FailNow(t, err.Error())
+		}
+	}
+}
+
+func serveStaticRequest(h *HTTPServer, url, acceptEncoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", url, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestHttp_static_encoding(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestPublicApp(t, h, map[string]string{
+		"app.js":    "plain",
+		"app.js.br": "brotli",
+		"app.js.gz": "gzip",
+		"other.js":  "other",
+	})
+
+	tests := []struct {
+		path, acceptEncoding string
+		body, encoding       string
+	}{
+		{"/app.js", "gzip, deflate, br", "brotli", "br"},
+		{"/app.js", "gzip, br;q=0", "gzip", "gzip"},
+		{"/app.js", "", "plain", ""},
+		{"/other.js", "br", "other", ""},
+	}
+
+	for _, tt := range tests {
+		rec := serveStaticRequest(h, "http://static.test"+tt.path, tt.acceptEncoding)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, tt.body, rec.Body.String(), tt.acceptEncoding)
+		assert.Equal(t, tt.encoding, rec.Header().Get("Content-Encoding"), tt.acceptEncoding)
+		assert.Contains(t, rec.Header().Get("Content-Type"), "javascript")
+
+		if tt.path == "/app.js" {
+			assert.Contains(t, rec.Header().Values("Vary"), "Accept-Encoding")
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+
+	req.Header.Set("Accept-Encoding", "gzip, br;q=0.5")
+	assert.True(t, acceptsEncoding(req, "br"))
+
+	req.Header.Set("Accept-Encoding", "gzip, br;q=0")
+	assert.False(t, acceptsEncoding(req, "br"))
+
+	req.Header.Set("Accept-Encoding", "*")
+	assert.True(t, acceptsEncoding(req, "br"))
+
+	req.Header.Set("Accept-Encoding", "identity")
+	assert.False(t, acceptsEncoding(req, "br"))
+}
+
+func TestHttp_maintenancePage(t *testing.T) {
+	defer helperAppCommand(0, 5)()
+
+	tests := []struct {
+		name   string
+		file   string
+		status int
+		body   string
+	}{
+		{"served", "maintenance.html", http.StatusServiceUnavailable, "<h1>Back soon</h1>"},
+		{"missing", "index.html", http.StatusInternalServerError, "unexpected exit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			makeTestPublicApp(t, h, map[string]string{tt.file: "<h1>Back soon</h1>"})
+
+			rec := serveTestRequest(h, "GET", "http://static.test/")
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.body)
+		})
+	}
+}
+
+func TestHttp_static_etag(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestPublicApp(t, h, map[string]string{
+		"app.js":    "plain",
+		"app.js.gz": "gzipped",
+	})
+
+	rec := serveStaticRequest(h, "http://static.test/app.js", "")
+	etag := rec.Header().Get("ETag")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Regexp(t, `^"[0-9a-f]+-5"$`, etag)
+
+	gzipped := serveStaticRequest(h, "http://static.test/app.js", "gzip")
+	assert.Regexp(t, `^"[0-9a-f]+-7-gzip"$`, gzipped.Header().Get("ETag"))
+
+	req := httptest.NewRequest("GET", "http://static.test/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	req = httptest.NewRequest("GET", "http://static.test/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", etag)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzipped", rec.Body.String())
+}
+
+func TestHttp_static_onlyForReads(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestPublicApp(t, h, map[string]string{"signup": "<form>"})
+
+	rec := serveTestRequest(h, "GET", "http://static.test/signup")
+	assert.Equal(t, "<form>", rec.Body.String())
+
+	rec = serveTestRequest(h, "HEAD", "http://static.test/signup")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "6", rec.Header().Get("Content-Length"))
+
+	for _, method := range []string{"POST", "PUT", "DELETE"} {
+		rec = serveTestRequest(h, method, "http://static.test/signup")
+		assert.Equal(t, "ok", rec.Body.String(), method)
+	}
+}
+
+func TestHttp_serveRootIndex(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestPublicApp(t, h, map[string]string{"index.html": "Welcome"})
+
+	rec := serveTestRequest(h, "GET", "http://static.test/")
+	assert.Equal(t, "ok", rec.Body.String(), "ServeRootIndex is off")
+
+	h.ServeRootIndex = true
+
+	rec = serveTestRequest(h, "GET", "http://static.test/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Welcome", rec.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = serveTestRequest(h, "POST", "http://static.test/")
+	assert.Equal(t, "ok", rec.Body.String())
+
+	os.Remove(filepath.Join(h.Pool.Dir, "static", "public", "index.html"))
+
+	rec = serveTestRequest(h, "GET", "http://static.test/")
+	assert.Equal(t, "ok", rec.Body.String(), "no index.html")
+}
+
+func TestHttp_globalStaticDir(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	dir, removeDir := testTempDir(t)
+	defer removeDir()
+
+	h.GlobalStaticDir = dir
+
+	for name, content := range map[string]string{"robots.txt": "global robots", "favicon.ico": "global icon"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			assert.FailNow(t, err.Error())
+		}
+	}
+
+	makeTestPublicApp(t, h, map[string]string{"favicon.ico": "app icon"})
+
+	backend := namedBackend("proxied")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "proxied", backend.URL, "")
+
+	tests := []struct {
+		method, url, body string
+	}{
+		{"GET", "http://static.test/robots.txt", "global robots"},
+		{"GET", "http://static.test/favicon.ico", "app icon"},
+		{"GET", "http://static.test/../robots.txt", "global robots"},
+		{"POST", "http://static.test/robots.txt", "ok"},
+		{"GET", "http://static.test/missing.txt", "ok"},
+		{"GET", "http://proxied.test/robots.txt", "proxied proxied.test "},
+	}
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, tt.method, tt.url)
+		assert.Equal(t, tt.body, rec.Body.String(), tt.method+" "+tt.url)
+	}
+
+	h.IgnoredStaticPaths = []string{"/robots.txt"}
+
+	rec := serveTestRequest(h, "GET", "http://static.test/robots.txt")
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func serveLargeStaticFile(t testing.TB, h *HTTPServer, size int) (*httptest.Server, []byte) {
+	content := make([]byte, size)
+	rand.Read(content)
+
+	dir := filepath.Join(h.Pool.Dir, "static", "public")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "large.bin"), content, 0644); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(h.Pool.Dir, "static", AppConfigFile), nil, 0644); err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	return httptest.NewServer(h), content
+}
+
+func getStaticFile(t testing.TB, srv *httptest.Server) []byte {
+	req, _ := http.NewRequest("GET", srv.URL+"/large.bin", nil)
+	req.Host = "static.test"
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	return body
+}
+
+func TestHttp_static_sendfile(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	for _, disabled := range []bool{false, true} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		h.DisableSendfile = disabled
+		h.JSONLogging = true
+		h.logOutput = ioutil.Discard
+
+		srv, content := serveLargeStaticFile(t, h, 4<<20)
+		defer srv.Close()
+
+		assert.True(t, bytes.Equal(content, getStaticFile(t, srv)), "disabled=%v", disabled)
+	}
+}
+
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+func TestCaptureWriter_readFrom(t *testing.T) {
+	rec := &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	cw := newCaptureWriter(rec, false)
+
+	n, err := cw.ReadFrom(strings.NewReader("hello"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	assert.True(t, rec.readFrom)
+	assert.Equal(t, int64(5), cw.size)
+	assert.Equal(t, "hello", rec.Body.String())
+
+	rec = &readerFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	cw = newCaptureWriter(rec, true)
+
+	cw.ReadFrom(strings.NewReader("hello"))
+
+	assert.False(t, rec.readFrom)
+	assert.Equal(t, "hello", cw.body.String())
+}
+
+func benchmarkStaticFile(b *testing.B, disableSendfile bool) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(b)
+	defer cleanup()
+
+	h.DisableSendfile = disableSendfile
+
+	const size = 8 << 20
+
+	srv, _ := serveLargeStaticFile(b, h, size)
+	defer srv.Close()
+
+	b.SetBytes(size)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		getStaticFile(b, srv)
+	}
+}
+
+func BenchmarkStaticFile_sendfile(b *testing.B) {
+	benchmarkStaticFile(b, false)
+}
+
+func BenchmarkStaticFile_buffered(b *testing.B) {
+	benchmarkStaticFile(b, true)
+}
+
+func TestHttp_static_head(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestPublicApp(t, h, map[string]string{"app.css": "body {}"})
+
+	get := serveTestRequest(h, "GET", "http://static.test/app.css")
+	head := serveTestRequest(h, "HEAD", "http://static.test/app.css")
+
+	assert.Equal(t, http.StatusOK, head.Code)
+	assert.Empty(t, head.Body.String())
+
+	for _, name := range []string{"Content-Length", "Content-Type", "Last-Modified", "ETag"} {
+		assert.NotEmpty(t, head.Header().Get(name), name)
+		assert.Equal(t, get.Header().Get(name), head.Header().Get(name), name)
+	}
+
+	req := httptest.NewRequest("HEAD", "http://static.test/app.css", nil)
+	req.Header.Set("If-None-Match", get.Header().Get("ETag"))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+}
+
+func TestHttp_static_range(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	content := make([]byte, 100)
+	rand.Read(content)
+
+	for _, disabled := range []bool{false, true} {
+		h, cleanup := newTestHTTPServer(t)
+		defer cleanup()
+
+		h.DisableSendfile = disabled
+
+		makeTestPublicApp(t, h, map[string]string{"clip.mp4": string(content)})
+
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+
+		get := func(ranges, ifRange string) (*http.Response, []byte) {
+			req, _ := http.NewRequest("GET", srv.URL+"/clip.mp4", nil)
+			req.Host = "static.test"
+			req.Header.Set("Range", ranges)
+			if ifRange != "" {
+				req.Header.Set("If-Range", ifRange)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				assert.FailNow(t, err.Error())
+			}
+
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			return resp, body
+		}
+
+		for ranges, want := range map[string][2]int{
+			"bytes=0-9":    {0, 10},
+			"bytes=-10":    {90, 100},
+			"bytes=90-":    {90, 100},
+			"bytes=5-5":    {5, 6},
+			"bytes=95-200": {95, 100},
+		} {
+			resp, body := get(ranges, "")
+
+			assert.Equal(t, http.StatusPartialContent, resp.StatusCode, ranges)
+			assert.Equal(t, fmt.Sprintf("bytes %d-%d/100", want[0], want[1]-1), resp.Header.Get("Content-Range"), ranges)
+			assert.Equal(t, content[want[0]:want[1]], body, ranges)
+		}
+
+		resp, body := get("bytes=0-4,10-14", "")
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+
+		mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		assert.NoError(t, err)
+		assert.Equal(t, "multipart/byteranges", mediaType)
+
+		parts := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+		for _, want := range [][2]int{{0, 5}, {10, 15}} {
+			part, err := parts.NextPart()
+			if err != nil {
+				assert.FailNow(t, err.Error())
+			}
+
+			data, _ := ioutil.ReadAll(part)
+			assert.Equal(t, "video/mp4", part.Header.Get("Content-Type"))
+			assert.Equal(t, fmt.Sprintf("bytes %d-%d/100", want[0], want[1]-1), part.Header.Get("Content-Range"))
+			assert.Equal(t, content[want[0]:want[1]], data)
+		}
+
+		resp, _ = get("bytes=200-300", "")
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, resp.StatusCode)
+		assert.Equal(t, "bytes */100", resp.Header.Get("Content-Range"))
+
+		resp, body = get("bytes=0-9", `"stale"`)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, content, body)
+
+		resp, body = get("bytes=0-9", resp.Header.Get("ETag"))
+		assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+		assert.Equal(t, content[:10], body)
+
+		req := httptest.NewRequest("HEAD", "http://static.test/clip.mp4", nil)
+		req.Header.Set("Range", "bytes=90-")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "bytes 90-99/100", rec.Header().Get("Content-Range"))
+		assert.Equal(t, "10", rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestServeStaticFile_headDoesNotOpen(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	dir, cleanupDir := testTempDir(t)
+	defer cleanupDir()
+
+	// Stat a real file, then serve a path that can't be opened with it.
+	real := filepath.Join(dir, "real.css")
+	ioutil.WriteFile(real, []byte("body {}"), 0644)
+
+	fi, err := os.Stat(real)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	missing := filepath.Join(dir, "missing.css")
+
+	rec := httptest.NewRecorder()
+	assert.True(t, h.serveStaticFile(rec, httptest.NewRequest("HEAD", "/missing.css", nil), missing, fi))
+	assert.Equal(t, "7", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "text/css; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	rec = httptest.NewRecorder()
+	assert.False(t, h.serveStaticFile(rec, httptest.NewRequest("GET", "/missing.css", nil), missing, fi))
+
+	// Pre-compressed siblings are only stat'ed too.
+	ioutil.WriteFile(missing+".gz", []byte("gzipped"), 0644)
+
+	req := httptest.NewRequest("HEAD", "/missing.css", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rec = httptest.NewRecorder()
+	assert.True(t, h.serveStaticFile(rec, req, missing, fi))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+}
+
+func TestHttp_static_publicDir(t *testing.T) {
+	defer helperAppCommand(0, 0)()
+
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	makeTestApp(t, h, "static", "public_dir: dist/\n")
+
+	for name, content := range map[string]string{
+		"dist/app.js":   "built",
+		"public/old.js": "stale",
+	} {
+		path := filepath.Join(h.Pool.Dir, "static", name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+
+	rec := serveTestRequest(h, "GET", "http://static.test/app.js")
+	assert.Equal(t, "built", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://static.test/old.js")
+	assert.Equal(t, "ok", rec.Body.String())
+}
+
+func TestHttp_acmeChallenge(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	dir, cleanupDir := testTempDir(t)
+	defer cleanupDir()
+
+	h.WellKnownDir = dir
+
+	os.MkdirAll(filepath.Join(dir, "acme-challenge"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "acme-challenge", "token123"), []byte("token123.key"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644)
+
+	backend := namedBackend("myapp")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"http://myapp.test/.well-known/acme-challenge/token123", "token123.key"},
+		{"http://unknown.test/.well-known/acme-challenge/token123", "token123.key"},
+		{"http://myapp.test/.well-known/acme-challenge/missing", "myapp myapp.test "},
+		{"http://myapp.test/.well-known/acme-challenge/../../secret", "myapp myapp.test "},
+	}
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, "GET", tt.url)
+		assert.Equal(t, tt.expected, rec.Body.String(), tt.url)
+	}
+
+	assert.Contains(t, eventsString(h.Events), `"event":"acme_challenge_served"`)
+}
+
+func TestHttp_blockPathTraversal(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := namedBackend("myapp")
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	tests := []struct {
+		path    string
+		blocked bool
+	}{
+		{"/assets/app.js", false},
+		{"/assets/app..js", false},
+		{"/../etc/passwd", true},
+		{"/assets/../../etc/passwd", true},
+		{"/%2e%2e/etc/passwd", true},
+		{"/assets/..%2f..%2fetc/passwd", true},
+		{"/assets/..%5c..%5cetc", true},
+	}
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, "GET", "http://myapp.test"+tt.path)
+		assert.Equal(t, http.StatusOK, rec.Code, tt.path)
+	}
+
+	h.BlockPathTraversal = true
+
+	for _, tt := range tests {
+		rec := serveTestRequest(h, "GET", "http://myapp.test"+tt.path)
+
+		if tt.blocked {
+			assert.Equal(t, http.StatusBadRequest, rec.Code, tt.path)
+		} else {
+			assert.Equal(t, http.StatusOK, rec.Code, tt.path)
+		}
+	}
+
+	assert.Contains(t, eventsString(h.Events), `"event":"path_traversal_blocked","host":"myapp.test","path":"/%2e%2e/etc/passwd"`)
+}
@@ -0,0 +1,128 @@
+package dev
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// withRequestTimeout applies RequestTimeout to req, except for streaming
+// paths and WebSockets. The returned function must be called when done.
+func (h *HTTPServer) withRequestTimeout(req *http.Request) (*http.Request, context.CancelFunc) {
+	if h.RequestTimeout <= 0 || h.isStreamingPath(req.URL.Path) || isWebSocketUpgrade(req) {
+		return req, func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), h.RequestTimeout)
+
+	return req.WithContext(ctx), cancel
+}
+
+func (h *HTTPServer) isStreamingPath(reqPath string) bool {
+	for _, prefix := range h.StreamingPaths {
+		if strings.HasPrefix(reqPath, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyError is used as the ErrorHandler of the reverse proxies. Timeouts
+// get a 504 so they can be told apart from apps that couldn't be reached.
+func (h *HTTPServer) proxyError(w http.ResponseWriter, req *http.Request, err error) {
+	status := http.StatusBadGateway
+
+	if isTimeout(err) {
+		status = http.StatusGatewayTimeout
+	}
+
+	headerTooLarge := isResponseHeaderTooLarge(err)
+
+	// Cancellations are gone clients and RequestTimeout cutting the request
+	// off, neither of which says anything about the app.
+	clientGone := errors.Is(err, context.Canceled) || req.Context().Err() != nil
+
+	if name, ok := req.Context().Value(circuitContextKey).(string); ok && !clientGone && !headerTooLarge {
+		h.circuitFailure(name)
+	}
+
+	// Timeouts are slow backends, anything else but a bad response means
+	// the backend is down.
+	if b, ok := req.Context().Value(backendContextKey).(*backend); ok && status == http.StatusBadGateway && !clientGone && !headerTooLarge {
+		if app, ok := req.Context().Value(appContextKey).(*App); ok {
+			app.backendFailed(b)
+		}
+	}
+
+	h.Events.Add("proxy_error",
+		"method", req.Method, "host", req.Host, "path", req.URL.Path,
+		"status", status, "error", err.Error())
+
+	msg := strings.ToLower(http.StatusText(status))
+
+	// The whole request ran past RequestTimeout, rather than the app
+	// failing to answer in time.
+	if req.Context().Err() == context.DeadlineExceeded && h.RequestTimeout > 0 {
+		h.Events.Add("request_timeout",
+			"method", req.Method, "host", req.Host, "path", req.URL.Path,
+			"timeout", h.RequestTimeout.String())
+
+		msg = fmt.Sprintf("%s: the app took longer than %s to respond", msg, h.RequestTimeout)
+	}
+
+	if isResponseHeaderTimeout(err) {
+		h.Events.Add("response_header_timeout",
+			"method", req.Method, "host", req.Host, "path", req.URL.Path,
+			"timeout", h.ResponseHeaderTimeout.String())
+
+		msg = fmt.Sprintf("%s: the app took longer than %s to start responding", msg, h.ResponseHeaderTimeout)
+	}
+
+	if headerTooLarge {
+		h.Events.Add("response_header_too_large",
+			"method", req.Method, "host", req.Host, "path", req.URL.Path,
+			"max_bytes", h.maxResponseHeaderBytes())
+
+		msg = fmt.Sprintf("%s: the app's response headers were over %d bytes", msg, h.maxResponseHeaderBytes())
+	}
+
+	http.Error(w, msg, status)
+}
+
+// isResponseHeaderTimeout reports whether err is the transport giving up
+// on an app that connected but sent no headers within ResponseHeaderTimeout.
+func isResponseHeaderTimeout(err error) bool {
+	return strings.Contains(err.Error(), "timeout awaiting response headers")
+}
+
+// isResponseHeaderTooLarge reports whether err is the transport giving up
+// on headers over MaxResponseHeaderBytes, which it has no error value for.
+func isResponseHeaderTooLarge(err error) bool {
+	return strings.Contains(err.Error(), "server response headers exceeded")
+}
+
+// maxResponseHeaderBytes is the limit the transports apply, Go's default
+// unless MaxResponseHeaderBytes is set.
+func (h *HTTPServer) maxResponseHeaderBytes() int64 {
+	if h.MaxResponseHeaderBytes > 0 {
+		return h.MaxResponseHeaderBytes
+	}
+
+	return defaultMaxResponseHeaderBytes
+}
+
+func isTimeout(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return true
+	}
+
+	return false
+}
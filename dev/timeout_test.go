@@ -0,0 +1,116 @@
+package dev
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func slowBackend(delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		w.Write([]byte("done"))
+	}))
+}
+
+func TestHttp_proxyErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(h *HTTPServer)
+		delay     time.Duration
+		path      string
+		status    int
+		body      string
+	}{
+		{"responseHeaderTimeout", func(h *HTTPServer) { h.ResponseHeaderTimeout = 50 * time.Millisecond },
+			time.Second, "/", http.StatusGatewayTimeout, "gateway timeout: the app took longer than 50ms to start responding\n"},
+		{"requestTimeout", func(h *HTTPServer) { h.RequestTimeout = 50 * time.Millisecond },
+			200 * time.Millisecond, "/report", http.StatusGatewayTimeout, "gateway timeout: the app took longer than 50ms to respond\n"},
+		{"streamingPath", func(h *HTTPServer) {
+			h.RequestTimeout = 50 * time.Millisecond
+			h.StreamingPaths = []string{"/stream"}
+		}, 200 * time.Millisecond, "/stream/events", http.StatusOK, "done"},
+		{"unreachable", func(h *HTTPServer) {}, -1, "/", http.StatusBadGateway, "bad gateway\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			tt.configure(h)
+			h.Setup()
+
+			backend := slowBackend(tt.delay)
+			defer backend.Close()
+
+			if tt.delay < 0 {
+				backend.Close()
+			}
+
+			linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+			rec := serveTestRequest(h, "GET", "http://myapp.test"+tt.path)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.body, rec.Body.String())
+
+			if tt.status != http.StatusOK {
+				assert.Contains(t, eventsString(h.Events),
+					fmt.Sprintf(`"event":"proxy_error","method":"GET","host":"myapp.test","path":"%s","status":%d`, tt.path, tt.status))
+			}
+
+			if tt.name == "requestTimeout" {
+				assert.Contains(t, eventsString(h.Events),
+					`"event":"request_timeout","method":"GET","host":"myapp.test","path":"/report","timeout":"50ms"`)
+			} else {
+				assert.NotContains(t, eventsString(h.Events), "request_timeout")
+			}
+
+			if tt.name == "responseHeaderTimeout" {
+				assert.Contains(t, eventsString(h.Events),
+					`"event":"response_header_timeout","method":"GET","host":"myapp.test","path":"/","timeout":"50ms"`)
+			} else {
+				assert.NotContains(t, eventsString(h.Events), "response_header_timeout")
+			}
+		})
+	}
+}
+
+func TestHttp_maxResponseHeaderBytes(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	h.MaxResponseHeaderBytes = 1024
+	h.Setup()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cookie" {
+			w.Header().Set("Set-Cookie", "session="+strings.Repeat("a", 2048))
+		}
+
+		w.Write([]byte("done"))
+	}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "myapp", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://myapp.test/cookie")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "bad gateway: the app's response headers were over 1024 bytes\n", rec.Body.String())
+	assert.Contains(t, eventsString(h.Events),
+		`"event":"response_header_too_large","method":"GET","host":"myapp.test","path":"/cookie","max_bytes":1024`)
+
+	rec = serveTestRequest(h, "GET", "http://myapp.test/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "done", rec.Body.String())
+}
@@ -0,0 +1,132 @@
+package dev
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// appProxies holds the proxies of apps with their own connection pool.
+type appProxies struct {
+	lock    sync.Mutex
+	proxies map[string]*appProxy
+}
+
+type appProxy struct {
+	*httputil.ReverseProxy
+	transport *http.Transport
+	h2c       *http2.Transport
+	cfg       AppConfig
+}
+
+func (p *appProxy) closeIdleConnections() {
+	if p.h2c != nil {
+		p.h2c.CloseIdleConnections()
+	} else {
+		p.transport.CloseIdleConnections()
+	}
+}
+
+// proxyFor returns nil for apps using the shared pool, rebuilding the proxy
+// when the config changed.
+func (ap *appProxies) proxyFor(h *HTTPServer, app *App) *httputil.ReverseProxy {
+	cfg := app.Config
+
+	if cfg.UpstreamMaxIdleConns <= 0 && cfg.UpstreamMaxConns <= 0 && !cfg.H2C {
+		return nil
+	}
+
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+
+	if ap.proxies == nil {
+		ap.proxies = make(map[string]*appProxy)
+	}
+
+	p, ok := ap.proxies[app.Name]
+	if ok && p.cfg.UpstreamMaxIdleConns == cfg.UpstreamMaxIdleConns &&
+		p.cfg.UpstreamMaxConns == cfg.UpstreamMaxConns && p.cfg.H2C == cfg.H2C {
+		return p.ReverseProxy
+	}
+
+	if ok {
+		p.closeIdleConnections()
+	}
+
+	if cfg.H2C {
+		transport := h.newH2CTransport(app.Scheme)
+
+		p = &appProxy{
+			ReverseProxy: h.newProxy(transport),
+			h2c:          transport,
+			cfg:          cfg,
+		}
+
+		ap.proxies[app.Name] = p
+
+		return p.ReverseProxy
+	}
+
+	var transport *http.Transport
+	if app.Scheme == "httpu" {
+		transport = h.newUnixTransport()
+	} else {
+		transport = h.newTCPTransport()
+	}
+
+	if cfg.UpstreamMaxIdleConns > 0 {
+		transport.MaxIdleConnsPerHost = cfg.UpstreamMaxIdleConns
+	}
+
+	transport.MaxConnsPerHost = cfg.UpstreamMaxConns
+
+	p = &appProxy{
+		ReverseProxy: h.newProxy(transport),
+		transport:    transport,
+		cfg:          cfg,
+	}
+
+	ap.proxies[app.Name] = p
+
+	return p.ReverseProxy
+}
+
+func (ap *appProxies) remove(app *App) {
+	ap.lock.Lock()
+	defer ap.lock.Unlock()
+
+	if p, ok := ap.proxies[app.Name]; ok {
+		p.closeIdleConnections()
+		delete(ap.proxies, app.Name)
+	}
+}
+
+// newH2CTransport speaks HTTP/2 over plain connections, to a unix socket
+// for httpu apps.
+func (h *HTTPServer) newH2CTransport(scheme string) *http2.Transport {
+	dialer := net.Dialer{
+		Timeout:   dialerTimeout,
+		KeepAlive: keepAlive,
+	}
+
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			if scheme == "httpu" {
+				socketPath, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+
+				return dialer.DialContext(ctx, "unix", socketPath)
+			}
+
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
@@ -0,0 +1,142 @@
+package dev
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// connCountingBackend reports the most connections it had open at once.
+func connCountingBackend() (*httptest.Server, func() int) {
+	var (
+		lock      sync.Mutex
+		open, max int
+	)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch state {
+		case http.StateNew:
+			open++
+			if open > max {
+				max = open
+			}
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+
+	backend.Start()
+
+	return backend, func() int {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return max
+	}
+}
+
+func TestHttp_upstreamConnectionPool(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	limited, limitedMax := connCountingBackend()
+	defer limited.Close()
+
+	shared, sharedMax := connCountingBackend()
+	defer shared.Close()
+
+	linkTestProxyApp(t, h, "limited", limited.URL, "upstream_max_conns: 1\nupstream_max_idle_conns: 1\n")
+	linkTestProxyApp(t, h, "shared", shared.URL, "")
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 3; i++ {
+		for _, name := range []string{"limited", "shared"} {
+			wg.Add(1)
+
+			go func(name string) {
+				defer wg.Done()
+
+				rec := serveTestRequest(h, "GET", "http://"+name+".test/")
+				assert.Equal(t, "ok", rec.Body.String())
+			}(name)
+		}
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, limitedMax())
+	assert.Equal(t, 3, sharedMax())
+
+	app, err := h.Pool.FindAppByDomainName("limited")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, h.proxies.proxies[app.Name].transport.MaxIdleConnsPerHost)
+	assert.Nil(t, h.proxies.proxyFor(h, &App{Name: "shared"}))
+}
+
+func TestHttp_idleConnLimits(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	// Unset, the transports behave as they always have.
+	for _, tr := range []*http.Transport{h.unixTransport, h.tcpTransport} {
+		assert.Equal(t, 0, tr.MaxIdleConns)
+		assert.Equal(t, 0, tr.MaxIdleConnsPerHost)
+		assert.Equal(t, time.Duration(0), tr.IdleConnTimeout)
+	}
+
+	h.MaxIdleConns = 50
+	h.MaxIdleConnsPerHost = 5
+	h.IdleConnTimeout = 30 * time.Second
+	h.Setup()
+
+	for _, tr := range []*http.Transport{h.unixTransport, h.tcpTransport} {
+		assert.Equal(t, 50, tr.MaxIdleConns)
+		assert.Equal(t, 5, tr.MaxIdleConnsPerHost)
+		assert.Equal(t, 30*time.Second, tr.IdleConnTimeout)
+	}
+
+	// Apps with their own pool keep the timeout, but use their own size.
+	app := &App{Name: "pooled", Scheme: "http", Config: AppConfig{UpstreamMaxIdleConns: 2}}
+	h.proxies.proxyFor(h, app)
+
+	tr := h.proxies.proxies["pooled"].transport
+	assert.Equal(t, 2, tr.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, tr.IdleConnTimeout)
+}
+
+func TestHttp_h2c(t *testing.T) {
+	h, cleanup := newTestHTTPServer(t)
+	defer cleanup()
+
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	linkTestProxyApp(t, h, "h2app", backend.URL, "h2c: true\n")
+	linkTestProxyApp(t, h, "h1app", backend.URL, "")
+
+	rec := serveTestRequest(h, "GET", "http://h2app.test/")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "HTTP/2.0", rec.Body.String())
+
+	rec = serveTestRequest(h, "GET", "http://h1app.test/")
+	assert.Equal(t, "HTTP/1.1", rec.Body.String())
+}
@@ -0,0 +1,47 @@
+package dev
+
+import (
+	"sync"
+	"time"
+)
+
+// appUsages counts the requests each app got and when it last got one,
+// keyed by app name so the numbers survive restarts.
+type appUsages struct {
+	lock  sync.Mutex
+	usage map[string]*appUsage
+}
+
+type appUsage struct {
+	requests     int64
+	lastAccessed time.Time
+}
+
+func (au *appUsages) record(name string) {
+	au.lock.Lock()
+	defer au.lock.Unlock()
+
+	if au.usage == nil {
+		au.usage = make(map[string]*appUsage)
+	}
+
+	u, ok := au.usage[name]
+	if !ok {
+		u = &appUsage{}
+		au.usage[name] = u
+	}
+
+	u.requests++
+	u.lastAccessed = time.Now()
+}
+
+func (au *appUsages) get(name string) appUsage {
+	au.lock.Lock()
+	defer au.lock.Unlock()
+
+	if u, ok := au.usage[name]; ok {
+		return *u
+	}
+
+	return appUsage{}
+}
@@ -0,0 +1,35 @@
+package dev
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isWebSocketUpgrade reports whether req asks to switch to the WebSocket
+// protocol.
+func isWebSocketUpgrade(req *http.Request) bool {
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// prepareWebSocketUpgrade adjusts a WebSocket handshake for app.
+func prepareWebSocketUpgrade(app *App, req *http.Request) {
+	if !isWebSocketUpgrade(req) {
+		return
+	}
+
+	if app.Config.DisableWebSocketExtensions {
+		req.Header.Del("Sec-WebSocket-Extensions")
+	}
+}
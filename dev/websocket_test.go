@@ -0,0 +1,116 @@
+package dev
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// websocketBackend picks the last offered subprotocol, accepts all offered
+// extensions and then echoes raw bytes.
+func websocketBackend(t *testing.T, offeredExtensions *atomic.Value) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protocols := strings.Split(r.Header.Get("Sec-WebSocket-Protocol"), ",")
+		chosen := strings.TrimSpace(protocols[len(protocols)-1])
+
+		offeredExtensions.Store(r.Header.Get("Sec-WebSocket-Extensions"))
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Protocol: %s\r\n", chosen)
+
+		if ext := r.Header.Get("Sec-WebSocket-Extensions"); ext != "" {
+			fmt.Fprintf(buf, "Sec-WebSocket-Extensions: %s\r\n", ext)
+		}
+
+		buf.WriteString("\r\n")
+		buf.Flush()
+
+		io.Copy(conn, buf)
+	}))
+}
+
+func openWebSocket(t *testing.T, addr, host, protocols, extensions string) (*http.Response, net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	fmt.Fprintf(conn, "GET /cable HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Protocol: %s\r\n"+
+		"Sec-WebSocket-Extensions: %s\r\n\r\n", host, protocols, extensions)
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		conn.Close()
+		assert.FailNow(t, err.Error())
+	}
+
+	return resp, conn, r
+}
+
+func TestHttp_websocket(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		extensions string
+	}{
+		{"default", "", "permessage-deflate"},
+		{"disableExtensions", "disable_websocket_extensions: true\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, cleanup := newTestHTTPServer(t)
+			defer cleanup()
+
+			var offered atomic.Value
+
+			backend := websocketBackend(t, &offered)
+			defer backend.Close()
+
+			linkTestProxyApp(t, h, "cable", backend.URL, tt.config)
+
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+
+			resp, conn, r := openWebSocket(t, srv.Listener.Addr().String(), "cable.test",
+				"actioncable-unsupported, actioncable-v1-json", "permessage-deflate")
+			defer conn.Close()
+
+			assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+			assert.Equal(t, "actioncable-v1-json", resp.Header.Get("Sec-WebSocket-Protocol"))
+			assert.Equal(t, tt.extensions, resp.Header.Get("Sec-WebSocket-Extensions"))
+			assert.Equal(t, tt.extensions, offered.Load())
+
+			conn.Write([]byte("ping\n"))
+
+			line, err := r.ReadString('\n')
+			assert.NoError(t, err)
+			assert.Equal(t, "ping\n", line)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package dev
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vektra/errors"
+)
+
+// captureWriter wraps a ResponseWriter to remember the status and size of
+// the response, and optionally a copy of its body.
+type captureWriter struct {
+	http.ResponseWriter
+
+	status int
+	size   int64
+	body   *bytes.Buffer
+}
+
+func newCaptureWriter(w http.ResponseWriter, captureBody bool) *captureWriter {
+	cw := &captureWriter{ResponseWriter: w}
+
+	if captureBody {
+		cw.body = new(bytes.Buffer)
+	}
+
+	return cw
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	n, err := cw.ResponseWriter.Write(b)
+	cw.size += int64(n)
+
+	if cw.body != nil {
+		cw.body.Write(b[:n])
+	}
+
+	return n, err
+}
+
+// ReadFrom keeps sendfile working while the response is captured.
+func (cw *captureWriter) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := cw.ResponseWriter.(io.ReaderFrom)
+	if !ok || cw.body != nil {
+		return io.Copy(writerOnly{cw}, r)
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	n, err := rf.ReadFrom(r)
+	cw.size += n
+
+	return n, err
+}
+
+// writerOnly hides every method but Write, so io.Copy doesn't loop back
+// into ReadFrom.
+type writerOnly struct {
+	io.Writer
+}
+
+// Status returns the response status, defaulting to 200 like net/http does
+// when nothing was written explicitly.
+func (cw *captureWriter) Status() int {
+	if cw.status == 0 {
+		return http.StatusOK
+	}
+
+	return cw.status
+}
+
+func (cw *captureWriter) Flush() {
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	cw.status = http.StatusSwitchingProtocols
+
+	return hj.Hijack()
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// headerCaseWriter respells the headers in names as they're written.
+type headerCaseWriter struct {
+	http.ResponseWriter
+
+	names []string
+}
+
+func (hw *headerCaseWriter) WriteHeader(status int) {
+	recaseHeaders(hw.Header(), hw.names)
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerCaseWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (hw *headerCaseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := hw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return hj.Hijack()
+}
+
+func (hw *headerCaseWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
+
+// streamingWriter flushes every write once a response is known to be larger
+// than threshold, so big downloads reach the client as they arrive.
+type streamingWriter struct {
+	http.ResponseWriter
+
+	threshold int64
+	written   int64
+	streaming bool
+}
+
+func (sw *streamingWriter) WriteHeader(status int) {
+	if n, err := strconv.ParseInt(sw.Header().Get("Content-Length"), 10, 64); err == nil && n > sw.threshold {
+		sw.streaming = true
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *streamingWriter) Write(b []byte) (int, error) {
+	n, err := sw.ResponseWriter.Write(b)
+
+	sw.written += int64(n)
+	if sw.written > sw.threshold {
+		sw.streaming = true
+	}
+
+	if sw.streaming && err == nil {
+		sw.Flush()
+	}
+
+	return n, err
+}
+
+func (sw *streamingWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *streamingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return hj.Hijack()
+}
+
+func (sw *streamingWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// flushPolicyWriter flushes responses of the streaming content types on
+// every write and ignores the proxy's periodic flushes for everything else,
+// so small responses are sent whole with a Content-Length.
+type flushPolicyWriter struct {
+	http.ResponseWriter
+
+	types     []string
+	streaming bool
+}
+
+func (fw *flushPolicyWriter) WriteHeader(status int) {
+	ctype, _, _ := mime.ParseMediaType(fw.Header().Get("Content-Type"))
+
+	for _, t := range fw.types {
+		if strings.EqualFold(t, ctype) {
+			fw.streaming = true
+		}
+	}
+
+	fw.ResponseWriter.WriteHeader(status)
+}
+
+func (fw *flushPolicyWriter) Write(b []byte) (int, error) {
+	n, err := fw.ResponseWriter.Write(b)
+
+	if fw.streaming && err == nil {
+		fw.flush()
+	}
+
+	return n, err
+}
+
+func (fw *flushPolicyWriter) Flush() {
+	if fw.streaming {
+		fw.flush()
+	}
+}
+
+func (fw *flushPolicyWriter) flush() {
+	if f, ok := fw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (fw *flushPolicyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := fw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	return hj.Hijack()
+}
+
+func (fw *flushPolicyWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
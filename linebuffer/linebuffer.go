@@ -1,21 +1,85 @@
 package linebuffer
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"time"
 )
 
 const DefaultSize = 1024
 
+// DefaultBlockTimeout is how long Append waits for room under the Block
+// policy when BlockTimeout isn't set.
+const DefaultBlockTimeout = 100 * time.Millisecond
+
+// OverflowPolicy decides what Append does once the buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest overwrites the oldest line, keeping the most recent Size
+	// lines.
+	DropOldest OverflowPolicy = iota
+
+	// DropNewest keeps the buffer as is and discards the line being
+	// appended.
+	DropNewest
+
+	// Block waits up to BlockTimeout for the buffer to be drained, then
+	// discards the line being appended.
+	Block
+)
+
+var ErrFull = errors.New("line buffer is full")
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Block:
+		return "block"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// ParseOverflowPolicy returns the policy named by s, as returned by
+// OverflowPolicy.String.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{DropOldest, DropNewest, Block} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+
+	return DropOldest, fmt.Errorf("unknown overflow policy '%s'", s)
+}
+
 type LineBuffer struct {
-	Size int
+	Size         int
+	Policy       OverflowPolicy
+	BlockTimeout time.Duration
 
-	lock  sync.Mutex
-	cur   int
-	lines []string
+	lock    sync.Mutex
+	cur     int
+	lines   []string
+	dropped int64
+	drained chan struct{}
 }
 
 func (lb *LineBuffer) Append(line string) error {
+	return lb.append(line, true)
+}
+
+// TryAppend is like Append, but drops the line instead of waiting for room.
+func (lb *LineBuffer) TryAppend(line string) error {
+	return lb.append(line, false)
+}
+
+func (lb *LineBuffer) append(line string, wait bool) error {
 	lb.lock.Lock()
 	defer lb.lock.Unlock()
 
@@ -25,22 +89,84 @@ func (lb *LineBuffer) Append(line string) error {
 
 	if len(lb.lines) < lb.Size {
 		lb.lines = append(lb.lines, line)
-	} else {
-		lb.lines[lb.cur] = line
-		lb.cur++
+		return nil
+	}
 
-		if lb.cur == len(lb.lines) {
-			lb.cur = 0
+	switch lb.Policy {
+	case DropNewest:
+		lb.dropped++
+		return ErrFull
+	case Block:
+		if !wait || !lb.waitForRoom() {
+			lb.dropped++
+			return ErrFull
 		}
+
+		lb.lines = append(lb.lines, line)
+		return nil
 	}
 
+	lb.lines[lb.cur] = line
+	lb.cur++
+
+	if lb.cur == len(lb.lines) {
+		lb.cur = 0
+	}
+
+	lb.dropped++
+
 	return nil
 }
 
+// waitForRoom waits, with lb.lock held, until Drain makes room in the buffer
+// or the block timeout passes.
+func (lb *LineBuffer) waitForRoom() bool {
+	timeout := lb.BlockTimeout
+	if timeout <= 0 {
+		timeout = DefaultBlockTimeout
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for len(lb.lines) >= lb.Size {
+		if lb.drained == nil {
+			lb.drained = make(chan struct{})
+		}
+
+		drained := lb.drained
+
+		lb.lock.Unlock()
+
+		select {
+		case <-drained:
+			lb.lock.Lock()
+		case <-timer.C:
+			lb.lock.Lock()
+			return len(lb.lines) < lb.Size
+		}
+	}
+
+	return true
+}
+
+// Dropped returns how many lines have been lost to the buffer being full,
+// whether they were overwritten or never added.
+func (lb *LineBuffer) Dropped() int64 {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	return lb.dropped
+}
+
 func (lb *LineBuffer) Do(x func(string) error) error {
 	lb.lock.Lock()
 	defer lb.lock.Unlock()
 
+	return lb.do(x)
+}
+
+func (lb *LineBuffer) do(x func(string) error) error {
 	var err error
 
 	if len(lb.lines) < lb.Size {
@@ -85,3 +211,53 @@ func (lb *LineBuffer) WriteTo(w io.Writer) (int64, error) {
 
 	return tot, err
 }
+
+// Drain writes the buffered lines to w like WriteTo and then empties the
+// buffer, waking up any Append blocked waiting for room.
+func (lb *LineBuffer) Drain(w io.Writer) (int64, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	var tot int64
+
+	err := lb.do(func(l string) error {
+		n, err := w.Write([]byte(l))
+		if err != nil {
+			return err
+		}
+		tot += int64(n)
+		return nil
+	})
+
+	if err != nil {
+		return tot, err
+	}
+
+	lb.empty()
+
+	return tot, nil
+}
+
+// Reset empties the buffer like Drain without writing the lines anywhere,
+// returning how many there were.
+func (lb *LineBuffer) Reset() int {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+
+	n := len(lb.lines)
+
+	lb.empty()
+
+	return n
+}
+
+// empty clears the buffer with lb.lock held.
+func (lb *LineBuffer) empty() {
+	lb.lines = nil
+	lb.cur = 0
+
+	if lb.drained != nil {
+		close(lb.drained)
+		lb.drained = nil
+	}
+}
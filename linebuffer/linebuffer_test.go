@@ -3,6 +3,7 @@ package linebuffer
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -72,4 +73,136 @@ func TestLineBuffer(t *testing.T) {
 		assert.Equal(t, "hello7", lines[2])
 	})
 
+	t.Run("counts lines dropped by drop-oldest", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 2
+
+		lb.Append("hello1")
+		lb.Append("hello2")
+		lb.Append("hello3")
+
+		assert.Equal(t, []string{"hello2", "hello3"}, collect(&lb))
+		assert.Equal(t, int64(1), lb.Dropped())
+	})
+
+	t.Run("keeps the oldest lines with drop-newest", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 2
+		lb.Policy = DropNewest
+
+		assert.NoError(t, lb.Append("hello1"))
+		assert.NoError(t, lb.Append("hello2"))
+		assert.Equal(t, ErrFull, lb.Append("hello3"))
+		assert.Equal(t, ErrFull, lb.Append("hello4"))
+
+		assert.Equal(t, []string{"hello1", "hello2"}, collect(&lb))
+		assert.Equal(t, int64(2), lb.Dropped())
+	})
+
+	t.Run("drops the new line when blocking times out", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 2
+		lb.Policy = Block
+		lb.BlockTimeout = 20 * time.Millisecond
+
+		lb.Append("hello1")
+		lb.Append("hello2")
+
+		start := time.Now()
+		assert.Equal(t, ErrFull, lb.Append("hello3"))
+		assert.True(t, time.Since(start) >= 20*time.Millisecond)
+
+		assert.Equal(t, []string{"hello1", "hello2"}, collect(&lb))
+		assert.Equal(t, int64(1), lb.Dropped())
+	})
+
+	t.Run("TryAppend doesn't wait for room", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 1
+		lb.Policy = Block
+		lb.BlockTimeout = 5 * time.Second
+
+		lb.Append("hello1")
+
+		start := time.Now()
+		assert.Equal(t, ErrFull, lb.TryAppend("hello2"))
+		assert.True(t, time.Since(start) < time.Second)
+
+		assert.Equal(t, []string{"hello1"}, collect(&lb))
+		assert.Equal(t, int64(1), lb.Dropped())
+	})
+
+	t.Run("blocked appends resume once drained", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 2
+		lb.Policy = Block
+		lb.BlockTimeout = 5 * time.Second
+
+		lb.Append("hello1")
+		lb.Append("hello2")
+
+		done := make(chan error)
+
+		go func() {
+			done <- lb.Append("hello3")
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+
+		var buf bytes.Buffer
+
+		_, err := lb.Drain(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello1hello2", buf.String())
+
+		assert.NoError(t, <-done)
+
+		assert.Equal(t, []string{"hello3"}, collect(&lb))
+		assert.Equal(t, int64(0), lb.Dropped())
+	})
+
+	t.Run("reset empties the buffer", func(t *testing.T) {
+		var lb LineBuffer
+
+		lb.Size = 2
+
+		lb.Append("hello1")
+		lb.Append("hello2")
+		lb.Append("hello3")
+
+		assert.Equal(t, 2, lb.Reset())
+		assert.Empty(t, collect(&lb))
+
+		lb.Append("hello4")
+
+		assert.Equal(t, []string{"hello4"}, collect(&lb))
+		assert.Equal(t, int64(1), lb.Dropped())
+	})
+
+	t.Run("parses policy names", func(t *testing.T) {
+		for _, p := range []OverflowPolicy{DropOldest, DropNewest, Block} {
+			parsed, err := ParseOverflowPolicy(p.String())
+			assert.NoError(t, err)
+			assert.Equal(t, p, parsed)
+		}
+
+		_, err := ParseOverflowPolicy("drop-everything")
+		assert.Error(t, err)
+	})
+}
+
+func collect(lb *LineBuffer) []string {
+	var lines []string
+
+	lb.Do(func(x string) error {
+		lines = append(lines, x)
+		return nil
+	})
+
+	return lines
 }